@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+const (
+	defaultCacheTTL = time.Hour
+)
+
+// repoKey returns the key used for caching the response
+// to the given request.
+func repoKey(req *lib.RepoRequest) string {
+	return req.Path + "|" + req.Revision
+}
+
+type cacheEntry struct {
+	Time time.Time `json:"time"`
+	Repo *lib.Repo `json:"repo"`
+}
+
+// repoCache stores API responses on disk, so they can be
+// reused by subsequent invocations of the command.
+type repoCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// newRepoCache returns a cache for the responses from the given
+// API host, stored under the user cache directory (which might
+// be overridden with GOPKGS_CACHE_DIR).
+func newRepoCache(host string, ttl time.Duration) (*repoCache, error) {
+	dir := os.Getenv("GOPKGS_CACHE_DIR")
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(userDir, "gopkgs")
+	}
+	return &repoCache{
+		dir: filepath.Join(dir, strings.Replace(host, ":", "_", -1)),
+		ttl: ttl,
+	}, nil
+}
+
+func (c *repoCache) filename(req *lib.RepoRequest) string {
+	sum := sha1.Sum([]byte(repoKey(req)))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get returns the cached response for the given request, or nil
+// if there's no cached response or it has expired. If stale is
+// true, expired responses are also returned.
+func (c *repoCache) Get(req *lib.RepoRequest, stale bool) *lib.Repo {
+	data, err := ioutil.ReadFile(c.filename(req))
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Repo == nil {
+		return nil
+	}
+	if !stale && (c.ttl <= 0 || time.Since(entry.Time) > c.ttl) {
+		return nil
+	}
+	return entry.Repo
+}
+
+// Put stores the response for the given request. If the cache
+// is disabled (its TTL is not positive), it does nothing.
+func (c *repoCache) Put(req *lib.RepoRequest, repo *lib.Repo) error {
+	if c.ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(&cacheEntry{Time: time.Now(), Repo: repo})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it, so concurrent
+	// readers never see a partially written entry.
+	tmp, err := ioutil.TempFile(c.dir, "tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.filename(req))
+}
+
+// offlineError is returned when running in offline mode and some
+// requests can't be answered from the cache.
+type offlineError struct {
+	missing []string
+}
+
+func (e *offlineError) Error() string {
+	return fmt.Sprintf("offline mode: no cached information for %s", strings.Join(e.missing, ", "))
+}
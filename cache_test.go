@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+func TestRepoCache(t *testing.T) {
+	t.Setenv("GOPKGS_CACHE_DIR", t.TempDir())
+	req := &lib.RepoRequest{Path: "github.com/rainycape/vfs"}
+	repo := &lib.Repo{Path: req.Path, GoPkgsPath: "gopkgs.com/vfs", Version: 1}
+	disabled, err := newRepoCache("localhost:8080", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disabled.Put(req, repo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(disabled.filename(req)); !os.IsNotExist(err) {
+		t.Error("disabled cache stored a response")
+	}
+	c, err := newRepoCache("localhost:8080", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(req, repo); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Get(req, false); got == nil || got.GoPkgsPath != repo.GoPkgsPath {
+		t.Errorf("expecting cached response %+v, got %+v", repo, got)
+	}
+	if got := c.Get(&lib.RepoRequest{Path: req.Path, Revision: "abcd"}, false); got != nil {
+		t.Errorf("expecting no cached response for another revision, got %+v", got)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"sort"
+	"strings"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+type checkOptions struct {
+	Verbose bool `name:"v" help:"Verbose output"`
+}
+
+// importGraph contains all the packages reachable from a set of
+// root packages, recording how each one was reached.
+type importGraph struct {
+	// order contains the import paths in the order they were found
+	order []string
+	// parents maps each import path to the package which
+	// imported it first. Roots have no parent.
+	parents map[string]string
+}
+
+// walkImports walks the imports of the given packages using
+// breadth first search, so the recorded import chains are the
+// shortest ones.
+func walkImports(roots []*build.Package, verbose bool) *importGraph {
+	g := &importGraph{parents: make(map[string]string)}
+	seen := make(map[string]bool)
+	var queue []*build.Package
+	for _, v := range roots {
+		if !seen[v.ImportPath] {
+			seen[v.ImportPath] = true
+			g.order = append(g.order, v.ImportPath)
+			queue = append(queue, v)
+		}
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, imp := range pkg.Imports {
+			if imp == "C" || seen[imp] {
+				continue
+			}
+			seen[imp] = true
+			dep, err := build.Import(imp, pkg.Dir, 0)
+			if err != nil {
+				if verbose {
+					fmt.Fprintf(stdout, "can't import %s (imported by %s): %s\n", imp, pkg.ImportPath, err)
+				}
+				continue
+			}
+			if dep.Goroot {
+				continue
+			}
+			g.order = append(g.order, dep.ImportPath)
+			g.parents[dep.ImportPath] = pkg.ImportPath
+			queue = append(queue, dep)
+		}
+	}
+	return g
+}
+
+// Chain returns the import chain from a root package to p.
+func (g *importGraph) Chain(p string) []string {
+	chain := []string{p}
+	for {
+		parent, ok := g.parents[p]
+		if !ok {
+			break
+		}
+		chain = append(chain, parent)
+		p = parent
+	}
+	for ii, jj := 0, len(chain)-1; ii < jj; ii, jj = ii+1, jj-1 {
+		chain[ii], chain[jj] = chain[jj], chain[ii]
+	}
+	return chain
+}
+
+// repoVariant is a path under which a repository is imported.
+type repoVariant struct {
+	// Path is the repository path, including the version or
+	// revision for gopkgs.com imports.
+	Path string `json:"path"`
+	// First is the first package found using this path
+	First string `json:"first"`
+	// Chain is the import chain from a root package to First
+	Chain []string `json:"chain,omitempty"`
+}
+
+func checkCommand(args []string, opts *checkOptions) error {
+	pkgs, err := importPackages(args)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		return fmt.Errorf("no packages to check")
+	}
+	g := walkImports(pkgs, opts.Verbose)
+	// Map from repository variant to the package which uses it first
+	variants := make(map[string]*repoVariant)
+	var variantOrder []string
+	// gopkgs.com paths which need to be resolved using the API
+	var unresolved []string
+	pending := make(map[string]bool)
+	for _, p := range g.order {
+		var path string
+		if imp, err := lib.ParseImportPath(p); err == nil {
+			path = imp.Root()
+			if base := imp.Base(); imp.OriginalPath() == "" && !pending[base] {
+				pending[base] = true
+				unresolved = append(unresolved, base)
+			}
+		} else if root, _ := splitRepository(p); root != "" {
+			path = root
+		} else {
+			continue
+		}
+		if variants[path] == nil {
+			variants[path] = &repoVariant{Path: path, First: p}
+			variantOrder = append(variantOrder, path)
+		}
+	}
+	// Resolve the original paths for gopkgs.com short names
+	originals := make(map[string]string)
+	if len(unresolved) > 0 {
+		st := new(rewriteState)
+		repos, err := st.RequestRepos(unresolved)
+		if err != nil {
+			fmt.Fprintf(stdout, "can't resolve gopkgs.com import paths, only checking versions: %s\n", err)
+		} else {
+			for ii, v := range repos {
+				if v.Error == "" && v.Path != "" {
+					originals[unresolved[ii]] = v.Path
+				}
+			}
+		}
+	}
+	// Group variants by repository
+	repos := make(map[string][]*repoVariant)
+	var repoOrder []string
+	for _, v := range variantOrder {
+		repo := v
+		if imp, err := lib.ParseImportPath(v); err == nil {
+			if orig := imp.OriginalPath(); orig != "" {
+				repo = orig
+			} else if orig := originals[imp.Base()]; orig != "" {
+				repo = orig
+			} else {
+				repo = imp.Base()
+			}
+		}
+		if repos[repo] == nil {
+			repoOrder = append(repoOrder, repo)
+		}
+		repos[repo] = append(repos[repo], variants[v])
+	}
+	sort.Strings(repoOrder)
+	conflicts := 0
+	for _, repo := range repoOrder {
+		vs := repos[repo]
+		for _, v := range vs {
+			v.Chain = g.Chain(v.First)
+		}
+		rec := &record{Command: "check", ImportPath: repo, Variants: vs, Status: "ok"}
+		if len(vs) > 1 {
+			rec.Status = "conflict"
+		}
+		emit(rec)
+		if len(vs) < 2 {
+			if opts.Verbose {
+				fmt.Fprintf(stdout, "%s: ok, imported as %s\n", repo, vs[0].Path)
+			}
+			continue
+		}
+		conflicts++
+		fmt.Fprintf(stdout, "%s is imported under %d different paths:\n", repo, len(vs))
+		for _, v := range vs {
+			fmt.Fprintf(stdout, "\t%s\n", v.Path)
+			fmt.Fprintf(stdout, "\t\t%s\n", strings.Join(v.Chain, " -> "))
+		}
+	}
+	if conflicts > 0 {
+		return fmt.Errorf("%d repositories are imported under more than one path", conflicts)
+	}
+	fmt.Fprintf(stdout, "no repositories imported under more than one path\n")
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+func TestCheckConflicts(t *testing.T) {
+	writeGOPATH(t, map[string]string{
+		"example.com/app/app.go":     "package app\n\nimport (\n\t_ \"example.com/dep1\"\n\t_ \"example.com/dep2\"\n)\n",
+		"example.com/dep1/dep1.go":   "package dep1\n\nimport _ \"github.com/foo/bar\"\n",
+		"example.com/dep2/dep2.go":   "package dep2\n\nimport _ \"gopkgs.com/bar.v1/sub\"\n",
+		"github.com/foo/bar/bar.go":  "package bar\n",
+		"gopkgs.com/bar.v1/sub/s.go": "package sub\n",
+	})
+	setTestAPI(t, func(req *lib.RepoRequest) *lib.Repo {
+		if req.Path != "gopkgs.com/bar" {
+			return &lib.Repo{Error: "unknown package"}
+		}
+		return &lib.Repo{Path: "github.com/foo/bar", GoPkgsPath: "gopkgs.com/bar", Version: 1}
+	})
+	out := captureStdout(t)
+	var records bytes.Buffer
+	jsonEncoder = json.NewEncoder(&records)
+	defer func() {
+		jsonEncoder = nil
+	}()
+	err := checkCommand([]string{"example.com/app"}, &checkOptions{})
+	if err == nil {
+		t.Fatal("expecting an error when a repository is imported under several paths")
+	}
+	if want := "1 repositories are imported under more than one path"; err.Error() != want {
+		t.Errorf("expecting error %q, got %q", want, err)
+	}
+	var rec record
+	if err := json.NewDecoder(&records).Decode(&rec); err != nil {
+		t.Fatal(err)
+	}
+	want := []*repoVariant{
+		{Path: "github.com/foo/bar", First: "github.com/foo/bar", Chain: []string{"example.com/app", "example.com/dep1", "github.com/foo/bar"}},
+		{Path: "gopkgs.com/bar.v1", First: "gopkgs.com/bar.v1/sub", Chain: []string{"example.com/app", "example.com/dep2", "gopkgs.com/bar.v1/sub"}},
+	}
+	if rec.ImportPath != "github.com/foo/bar" || rec.Status != "conflict" || !reflect.DeepEqual(rec.Variants, want) {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	output := out.String()
+	for _, v := range []string{
+		"github.com/foo/bar is imported under 2 different paths:\n",
+		"\tgithub.com/foo/bar\n\t\texample.com/app -> example.com/dep1 -> github.com/foo/bar\n",
+		"\tgopkgs.com/bar.v1\n\t\texample.com/app -> example.com/dep2 -> gopkgs.com/bar.v1/sub\n",
+	} {
+		if !strings.Contains(output, v) {
+			t.Errorf("expecting %q in output:\n%s", v, output)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"os"
+	"sort"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+type cleanOptions struct {
+	Interactive bool `name:"i" help:"Interactive mode - ask before removing each checkout"`
+	DryRun      bool `name:"n" help:"Dry run - only show the checkouts that would be removed"`
+	Verbose     bool `name:"v" help:"Verbose output"`
+}
+
+// usedGoPkgsRepositories returns the gopkgs.com repositories, including
+// their version or revision (e.g. gopkgs.com/vfs.v1), used by the given
+// packages, their tests and their dependencies. Imports which can't be
+// found are still taken into account, so a checkout is never considered
+// unused just because it's broken.
+func usedGoPkgsRepositories(pkgs []*build.Package, verbose bool) map[string]bool {
+	repos := make(map[string]bool)
+	visited := make(map[string]bool)
+	use := func(p string) {
+		if imp, err := lib.ParseImportPath(p); err == nil {
+			repos[imp.Root()] = true
+		}
+	}
+	var visit func(imports []string, srcDir string)
+	visit = func(imports []string, srcDir string) {
+		for _, imp := range imports {
+			if visited[imp] || imp == "C" {
+				continue
+			}
+			visited[imp] = true
+			use(imp)
+			pkg, err := build.Import(imp, srcDir, 0)
+			if err != nil {
+				if verbose {
+					fmt.Fprintf(os.Stderr, "can't import %s, ignoring its dependencies: %s\n", imp, err)
+				}
+				continue
+			}
+			if !pkg.Goroot {
+				visit(pkg.Imports, pkg.Dir)
+			}
+		}
+	}
+	for _, v := range pkgs {
+		// Roots might live inside a gopkgs.com checkout too
+		use(v.ImportPath)
+		visit(v.Imports, v.Dir)
+		visit(v.TestImports, v.Dir)
+		visit(v.XTestImports, v.Dir)
+	}
+	return repos
+}
+
+// localChanges fills in the revision and the working copy state of
+// the checkout of pkg into co, returning the reason why the checkout
+// has local changes which would be lost by removing it, or an empty
+// string if there are none. If the revision or the changes can't be
+// determined, an error is returned.
+func localChanges(pkg *localPackage, co *checkoutInfo) (string, error) {
+	rev, err := pkg.VCS.Revision(pkg.Dir)
+	if err != nil {
+		return "", fmt.Errorf("can't determine the revision: %s", err)
+	}
+	if rev == "" {
+		return "", errors.New("can't determine the revision")
+	}
+	co.Revision = rev
+	dirty, err := pkg.VCS.Dirty(pkg.Dir)
+	if err != nil {
+		return "", fmt.Errorf("can't check for local modifications: %s", err)
+	}
+	if dirty {
+		co.Dirty = true
+		return "it has local modifications", nil
+	}
+	unpushed, err := pkg.VCS.Unpushed(pkg.Dir)
+	if err != nil {
+		return "", fmt.Errorf("can't check for unpushed changes: %s", err)
+	}
+	if unpushed {
+		co.Unpushed = true
+		return "it has changes which haven't been pushed", nil
+	}
+	return "", nil
+}
+
+func cleanCommand(args []string, opts *cleanOptions) error {
+	if len(args) == 0 {
+		return errors.New("no packages specified - pass the packages whose dependencies should be kept (e.g. ./...)")
+	}
+	pkgs, err := importPackages(args)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		// Don't remove everything due to a mistyped argument
+		return errors.New("no packages found")
+	}
+	installed, err := listGoPkgsPackages()
+	if err != nil {
+		return err
+	}
+	sort.Slice(installed, func(i, j int) bool { return installed[i].ImportPath < installed[j].ImportPath })
+	used := usedGoPkgsRepositories(pkgs, opts.Verbose)
+	unused := 0
+	removed := 0
+	failed := 0
+	for _, v := range installed {
+		imp, err := lib.ParseImportPath(v.ImportPath)
+		if err != nil {
+			if opts.Verbose {
+				fmt.Fprintf(stdout, "keeping %s: %s\n", v.ImportPath, err)
+			}
+			continue
+		}
+		if used[imp.Root()] {
+			if opts.Verbose {
+				fmt.Fprintf(stdout, "keeping %s: in use\n", v.ImportPath)
+			}
+			continue
+		}
+		unused++
+		co := &checkoutInfo{Dir: v.Dir, VCS: v.VCS.Name}
+		rec := &record{Command: "clean", ImportPath: v.ImportPath, Checkout: co}
+		// Never remove a checkout which might have local changes, nor
+		// one which couldn't be restored because its revision is unknown.
+		reason, err := localChanges(v, co)
+		if err != nil {
+			fmt.Fprintf(stdout, "keeping %s: %s\n", v.ImportPath, err)
+			rec.Status = "kept"
+			rec.SetError(err)
+			emit(rec)
+			continue
+		}
+		if reason != "" {
+			fmt.Fprintf(stdout, "keeping %s: %s\n", v.ImportPath, reason)
+			rec.Status = "modified"
+			emit(rec)
+			continue
+		}
+		if opts.DryRun {
+			rec.Status = "unused"
+			fmt.Fprintf(stdout, "would remove %s (%s)\n", v.ImportPath, v.Dir)
+			emit(rec)
+			continue
+		}
+		if opts.Interactive && !confirm(fmt.Sprintf("remove %s (%s)?", v.ImportPath, v.Dir)) {
+			rec.Status = "kept"
+			emit(rec)
+			continue
+		}
+		if err := os.RemoveAll(v.Dir); err != nil {
+			fmt.Fprintf(stdout, "error removing %s: %s\n", v.ImportPath, err)
+			rec.Status = "error"
+			rec.SetError(err)
+			failed++
+		} else {
+			fmt.Fprintf(stdout, "removed %s (%s)\n", v.ImportPath, v.Dir)
+			rec.Status = "removed"
+			removed++
+		}
+		emit(rec)
+	}
+	if unused == 0 {
+		fmt.Fprintf(stdout, "all %d gopkgs.com checkouts are in use\n", len(installed))
+		return nil
+	}
+	if opts.DryRun {
+		fmt.Fprintf(stdout, "found %d unused gopkgs.com checkouts\n", unused)
+	} else {
+		fmt.Fprintf(stdout, "removed %d of %d unused gopkgs.com checkouts\n", removed, unused)
+	}
+	if failed > 0 {
+		return fmt.Errorf("couldn't remove %d checkouts", failed)
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"runtime"
+
 	"gopkgs.com/command.v1"
 )
 
@@ -10,36 +12,190 @@ gopkgs.com import paths when possible.
 
 Packages might be specified either as import paths, or file paths to package
 directories (either absolute or relative). If no packages are specified, the package
-at the current directory is used.
+at the current directory is used. As with the go tool, packages might also be
+specified as patterns containing "...", like ./... or github.com/user/...
+Directories named testdata or vendor, as well as the ones starting with "." or "_",
+are ignored when expanding patterns. Packages are rewritten in dependency order.
 
 By default, rewrite will try to use package versions, falling back to package revisions
 when the package has no available versions. This behavior can be changed using the -r
 flag.
 
+The -local flag makes rewrite pin each package on the revision currently checked
+out in GOPATH, rather than on the latest one, so the rewritten imports point to
+exactly the same code the package was built and tested with. Both git and hg
+checkouts are supported. Since library mode doesn't allow pinning on revisions,
+-local is rejected for packages in library mode (see -lib below).
+
+Before rewriting an import, the package is type checked to find every place where
+values with types from the imported package meet another package which still
+imports its original path (e.g. type assertions, calls to functions from other
+3rd party packages or values passed to reflect). Those imports are kept and each
+conflict is printed. If the package can't be type checked, only type assertions
+and type switches are considered.
+
+Test files, both in the package and in its external test package, are rewritten
+too, so the package and its tests always import the same copy of each dependency.
+Use -notests to leave test files untouched.
+
+The -n flag only shows which imports would be rewritten, while -d prints a unified
+diff of each file instead of writing it, which might be applied later with
+patch -p1. Files in the working directory are named relative to it, the rest
+relative to their GOPATH entry's src directory. Neither of them downloads any
+packages.
+
 Users should generally avoid pinning packages on exact revisions when writing reusable
 libraries. For this reason, the -lib flag defaults to auto, which will enable library mode
 when the package name is different than "main". When enabled, this flag causes rewrite to
 refuse pinning packages on revisions, taking precedence over the -r flag. This behavior
 might be overridden by setting either -lib=true or -lib=false, but is usually not
 recommended to do so.`
+	unrewriteHelp = `unrewrite reverts the changes made by rewrite, replacing gopkgs.com
+import paths in the given packages with the original import paths for each package,
+like github.com/rainycape/vfs, code.google.com/p/go.tools or bitbucket.org/user/repo.
+
+Packages are specified in the same way as in rewrite. Imports pinned on either
+versions or revisions are rewritten, while the package subdirectory, if any, is
+preserved. As in rewrite, -d prints a unified diff rather than writing the files.`
+	serveHelp = `serve runs a gopkgs.com API server, which serves the git repositories
+found in the directory specified by -repos. Repositories might be either bare
+mirrors or regular checkouts, and each one is served at gopkgs.com/<name>, where
+<name> is its path relative to the directory, without any .git suffix.
+
+Versions are discovered from tags or branches named vN. The original import path
+of each repository is taken from its gopkgs.path git configuration key or, if it's
+not set, from the URL of its origin remote. Setting gopkgs.allowunpinned to true in
+a repository allows using it without pinning on a version or revision.
+
+The server also answers go get requests for versioned import paths, like
+gopkgs.com/vfs.v1 or gopkgs.com/vfs.r1a2b3c, serving a git repository whose
+default branch points to the requested version or revision. Since revisions
+might not be at the tip of any branch, uploadpack.allowReachableSHA1InWant is
+set to true in each repository, so the server must be able to write to them.
+
+To make the gopkgs command use a local server, set GOPKGS_API_HOST to its address
+(e.g. GOPKGS_API_HOST=localhost:8080).`
+	freezeHelp = `freeze records the exact revision of every third party repository
+used by the given packages, either directly or through their dependencies, into a
+lock file (gopkgs.lock by default). Import paths are not modified.
+
+Packages are specified in the same way as in rewrite, defaulting to ./... The
+recorded revision is the one currently checked out in GOPATH. Imports already
+pointing to gopkgs.com are ignored, since they're already pinned.`
+
+	restoreHelp = `restore reads a lock file written by freeze (gopkgs.lock by default) and
+checks out the recorded revision of each repository in GOPATH, downloading the
+repositories which are not available locally.`
+	outdatedHelp = `outdated lists the gopkgs.com imports in the given packages which are
+pinned on a version or revision for which a newer one is available. Imports pinned
+on a version are outdated when a newer major version exists, while imports pinned
+on a revision are outdated when it's not the latest revision.
+
+Packages are specified in the same way as in rewrite, defaulting to ./... If any
+imports are outdated, the exit status is non-zero, so this command can be used
+to enforce up to date imports in continuous integration.`
+	upgradeHelp = `upgrade rewrites gopkgs.com import paths in the given packages which are
+pinned on a version or revision, so they point to a newer one.
+
+Packages are specified in the same way as in rewrite. Imports keep their kind of pin:
+the ones pinned on a version are upgraded to the latest version, while the ones pinned
+on a revision are upgraded to the latest revision. The -r flag switches imports pinned
+on versions to revisions, while library mode (see -lib in rewrite) switches imports
+pinned on revisions to versions. Imports which are already pinned on the chosen
+version or a newer one are left untouched. As in rewrite, the package is type checked
+and imports whose values meet packages which still import the previous version (e.g.
+in type assertions) are never upgraded, and each conflict is printed. The -version
+and -revision values are checked against gopkgs.com before upgrading.
+
+To upgrade a single repository, specify its gopkgs.com import path using -repo (e.g.
+-repo gopkgs.com/vfs). In that case, -version or -revision might be used to choose
+a version or revision other than the latest one. The -n and -d flags work as in
+rewrite.`
+	checkHelp = `check walks the transitive imports of the given packages (usually main
+packages) and reports every 3rd party repository which is imported under more than one
+path, like github.com/foo/bar and gopkgs.com/bar.v1, or gopkgs.com/bar.v1 and
+gopkgs.com/bar.v2. Programs which do so link several copies of the same code, with
+duplicated global state and incompatible types.
+
+Packages are specified in the same way as in rewrite. For each conflicting repository,
+the shortest import chain leading to each path is printed. If any conflicts are found,
+the exit status is non-zero.`
 	importPathHelp = `
 
 <import-path> might be either the original package import path, like
-github.com/rainycape/vfs or code.google.com/p/go.tools, or either the
-import path at gopkgs.com, like gopkgs.com/vfs or gopkgs.com/gc/go.tools.`
+github.com/rainycape/vfs, code.google.com/p/go.tools or bitbucket.org/user/repo,
+or either the import path at gopkgs.com, like gopkgs.com/vfs, gopkgs.com/gc/go.tools
+or gopkgs.com/bb/user/repo.`
 
 	docHelp = `doc shows the package documentation for the given
 package in the default web browser. By default, doc will initially
 open the latest available version of the package. The -r flag 
-might be used to open the latest revision instead.` + importPathHelp
+might be used to open the latest revision instead. When using -json,
+the documentation URL is printed rather than opened.` + importPathHelp
+
+	infoHelp = `info resolves the given import paths using gopkgs.com and prints
+the information available for each repository, including its latest version
+and revision, the import paths pinned on them and the documentation URLs. Unlike
+doc and view, it doesn't require a web browser, so it's suitable for servers.
+The exit status is non-zero if any of the import paths can't be resolved.` + importPathHelp
 
 	getHelp = `get downloads packages using gopkgs.com import paths.
 By default, get will download the latest available version of the package.
-The -r flag might be used to download the latest revision instead.` + importPathHelp
+The -r flag might be used to download the latest revision instead, in which
+case that exact revision is checked out.
+
+Repositories are cloned directly into the first GOPATH entry using git or, for
+BitBucket and Google Code repositories which can't be cloned with git, hg.
+Repositories at gopkgs.com are cloned from the server set by GOPKGS_API_HOST
+or, when using -remote, from the given URL or local directory (e.g. a mirror
+served by gopkgs serve). Unlike go get, dependencies are neither downloaded nor
+installed. Packages from hosts which gopkgs doesn't know about are still
+downloaded using go get -d.
+Import paths might include "..." patterns, which are expanded using the
+packages already present in GOPATH. When using -u without any import paths,
+every gopkgs.com package checked out in GOPATH is updated, regardless of
+whether it uses git (including worktrees and submodules), hg, svn or bzr.
+
+Packages are downloaded in parallel, up to the number specified by -p (which
+defaults to the number of CPUs), and the output for each package is printed
+once its download finishes. When any download fails, get prints a summary of
+the failed packages and exits with a non-zero status.` + importPathHelp
+
+	cleanHelp = `clean removes the gopkgs.com checkouts in GOPATH which are not used by
+the given packages, their tests nor any of their dependencies. Every version and
+revision of a repository gets its own checkout, so they tend to pile up as
+import paths are upgraded. Pass all the packages whose dependencies should be
+kept, since any checkout not used by them is removed.
+
+Checkouts with modified or untracked files, or with commits, branches or
+stashes which haven't been pushed, are never removed. Use -n to list the
+checkouts which would be removed without removing them, or -i to confirm each
+removal.`
+
+	listHelp = `list shows every gopkgs.com package checked out in any GOPATH entry,
+together with its VCS and the revision checked out, whether its working copy
+has modified or untracked files, the path of the original repository and
+whether a newer version (or, for packages pinned on a revision or not pinned
+at all, a newer revision) is available. If gopkgs.com can't be reached, only the local state
+is shown.`
+
+	versionsHelp = `versions lists all the versions available for the given package,
+including the tag or branch providing each one and its date, as well as its latest
+revisions, together with the gopkgs.com import path for each of them. The -n
+flag controls how many revisions are listed. When several tags or branches provide
+the same major version (e.g. v1.2 and v1.10), the version import path (e.g.
+gopkgs.com/vfs.v1) resolves to the branch named vN if there's one or to the highest
+tag otherwise, so the other ones are listed with their revision import path.` + importPathHelp
+
+	flagsHelp = `flags describes the global flags, which are accepted by every command
+and might appear either before the command name or among its flags (e.g.
+gopkgs -json list or gopkgs list -json). Arguments after the command flags
+are passed to the command unchanged, even if they look like global flags.`
 
 	viewHelp = `view shows the given package at gopkgs.com in the
-default web browser. This command can be used to view all the available
-versions and revisions of a given package.` + importPathHelp
+default web browser. To list the available versions and revisions of a package
+in the terminal, use the versions command. When using -json, the URL
+is printed rather than opened.` + importPathHelp
 )
 
 var (
@@ -52,6 +208,69 @@ var (
 			Func:     rewriteSubcommand,
 			Options:  &rewriteOptions{Library: "auto"},
 		},
+		{
+			Name:     "unrewrite",
+			Help:     "Rewrite gopkgs.com import paths back to their original paths",
+			LongHelp: unrewriteHelp,
+			Usage:    "[pkg-1] [pkg-2] ... [pkg-n]",
+			Func:     unrewriteSubcommand,
+			Options:  &unrewriteOptions{},
+		},
+		{
+			Name:     "freeze",
+			Help:     "Record the revisions of third party repositories in a lock file",
+			LongHelp: freezeHelp,
+			Usage:    "[pkg-1] [pkg-2] ... [pkg-n]",
+			Func:     freezeCommand,
+			Options:  &freezeOptions{Output: lockFileName},
+		},
+		{
+			Name:     "restore",
+			Help:     "Check out the revisions recorded in a lock file",
+			LongHelp: restoreHelp,
+			Func:     restoreCommand,
+			Options:  &restoreOptions{Input: lockFileName},
+		},
+		{
+			Name:     "outdated",
+			Help:     "List pinned gopkgs.com imports with newer versions or revisions",
+			LongHelp: outdatedHelp,
+			Usage:    "[pkg-1] [pkg-2] ... [pkg-n]",
+			Func:     outdatedCommand,
+			Options:  &outdatedOptions{},
+		},
+		{
+			Name:     "upgrade",
+			Help:     "Upgrade pinned gopkgs.com imports to newer versions or revisions",
+			LongHelp: upgradeHelp,
+			Usage:    "[pkg-1] [pkg-2] ... [pkg-n]",
+			Func:     upgradeSubcommand,
+			Options:  &upgradeOptions{Library: "auto"},
+		},
+		{
+			Name:     "check",
+			Help:     "Find repositories imported under more than one path",
+			LongHelp: checkHelp,
+			Usage:    "[pkg-1] [pkg-2] ... [pkg-n]",
+			Func:     checkCommand,
+			Options:  &checkOptions{},
+		},
+		{
+			Name:     "info",
+			Help:     "Show package information from gopkgs.com",
+			LongHelp: infoHelp,
+			Usage:    "<import-path-1> [import-path-2] ... [import-path-n]",
+			Func:     infoCommand,
+			Options:  nil,
+		},
+		{
+			Name:     "clean",
+			Help:     "Remove the gopkgs.com checkouts not used by the given packages",
+			LongHelp: cleanHelp,
+			Usage:    "<pkg-1> [pkg-2] ... [pkg-n]",
+			Func:     cleanCommand,
+			Options:  &cleanOptions{},
+		},
 		{
 			Name:     "doc",
 			Help:     "Open package documentation in the default browser",
@@ -66,7 +285,36 @@ var (
 			Usage:    "<import-path-1> [import-path-2] ... [import-path-n]",
 			LongHelp: getHelp,
 			Func:     getCommand,
-			Options:  &getOptions{},
+			Options:  &getOptions{Parallel: runtime.NumCPU()},
+		},
+		{
+			Name:     "list",
+			Help:     "List the gopkgs.com packages installed in GOPATH",
+			LongHelp: listHelp,
+			Func:     listCommand,
+			Options:  nil,
+		},
+		{
+			Name:     "serve",
+			Help:     "Run a gopkgs.com API server backed by local git repositories",
+			LongHelp: serveHelp,
+			Func:     serveCommand,
+			Options:  &serveOptions{Addr: ":8080", Dir: "."},
+		},
+		{
+			Name:     "versions",
+			Help:     "List the available versions and revisions of a package",
+			LongHelp: versionsHelp,
+			Usage:    "<import-path>",
+			Func:     versionsCommand,
+			Options:  &versionsOptions{Revisions: 10},
+		},
+		{
+			Name:     "flags",
+			Help:     "Describe the global flags accepted by every command",
+			LongHelp: flagsHelp,
+			Func:     flagsCommand,
+			Options:  nil,
 		},
 		{
 			Name:     "view",
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// diffPath returns the path used for filename in the diff headers. Files
+// in the working directory use their path relative to it, while the ones
+// outside of it use their path relative to the GOPATH entry containing
+// them (e.g. github.com/user/repo/file.go). Files which are in neither
+// of them are rejected, since patch won't apply paths starting with ../
+func diffPath(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	var roots []string
+	if wd, err := os.Getwd(); err == nil {
+		roots = append(roots, wd)
+	}
+	roots = append(roots, build.Default.SrcDirs()...)
+	for _, v := range roots {
+		rel, err := filepath.Rel(v, abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel), nil
+		}
+	}
+	return "", fmt.Errorf("can't diff %s, it's outside of both the working directory and GOPATH", filename)
+}
+
+// diff returns a unified diff between b1 and b2, using filename in the
+// headers with the a/ and b/ prefixes, so it can be applied with patch -p1.
+// See diffPath for how filename is written in the headers. Like gofmt, it
+// relies on the diff command.
+func diff(filename string, b1, b2 []byte) ([]byte, error) {
+	filename, err := diffPath(filename)
+	if err != nil {
+		return nil, err
+	}
+	f1, err := writeTempFile("gopkgs", b1)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTempFile("gopkgs", b2)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+	data, err := exec.Command("diff", "-u", f1, f2).CombinedOutput()
+	if len(data) == 0 {
+		return nil, err
+	}
+	// diff exits with a non-zero status when the files don't match.
+	// Ignore that failure as long as we get output.
+	lines := bytes.SplitN(data, []byte("\n"), 3)
+	if len(lines) < 3 {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString("--- a/" + filename + "\n")
+	buf.WriteString("+++ b/" + filename + "\n")
+	buf.Write(lines[2])
+	return buf.Bytes(), nil
+}
+
+func writeTempFile(prefix string, data []byte) (string, error) {
+	file, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if err1 := file.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
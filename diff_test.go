@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileDiffHeaders(t *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		t.Skip("diff is not installed")
+	}
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/a/a.go": "package a\n\nimport _ \"github.com/a/b\"\n",
+	})
+	dir := filepath.Join(gopath, "src", "example.com", "a")
+	outside := t.TempDir()
+	tests := []struct {
+		wd     string
+		header string
+	}{
+		{dir, "--- a/a.go\n+++ b/a.go\n"},
+		{filepath.Dir(dir), "--- a/a/a.go\n+++ b/a/a.go\n"},
+		// Outside of the working directory, relative to GOPATH
+		{outside, "--- a/example.com/a/a.go\n+++ b/example.com/a/a.go\n"},
+	}
+	rewritten := map[string]string{"github.com/a/b": "gopkgs.com/gh/a/b.v1"}
+	for _, v := range tests {
+		t.Chdir(v.wd)
+		fset, _, files := parsePackage(t, "example.com/a")
+		for k, f := range files {
+			data, err := fileDiff(fset, f, k, rewritten)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte(v.header)) {
+				t.Errorf("expecting diff from %s to start with\n%s\ngot\n%s", v.wd, v.header, data)
+			}
+			if !bytes.Contains(data, []byte("-import _ \"github.com/a/b\"\n+import _ \"gopkgs.com/gh/a/b.v1\"\n")) {
+				t.Errorf("expecting the rewritten import in diff:\n%s", data)
+			}
+		}
+	}
+	// Outside of both the working directory and GOPATH
+	file := filepath.Join(outside, "b.go")
+	if err := os.WriteFile(file, []byte("package b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	if _, err := diff(file, []byte("package b\n"), []byte("package c\n")); err == nil {
+		t.Errorf("expecting an error when diffing %s", file)
+	}
+}
@@ -20,6 +20,7 @@ func docCommand(args []string, opts *docOptions) error {
 	}
 	repo, err := Repo(req)
 	if err != nil {
+		emit(&record{Command: "doc", ImportPath: req.Path, Error: err.Error()})
 		return err
 	}
 	var url string
@@ -28,5 +29,9 @@ func docCommand(args []string, opts *docOptions) error {
 	} else {
 		url = repo.VersionDocumentation()
 	}
+	if jsonOutput() {
+		emit(&record{Command: "doc", Repo: repo, URL: url})
+		return nil
+	}
 	return browser.Open(url)
 }
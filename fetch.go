@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+// fetchOptions control how repositories are downloaded.
+type fetchOptions struct {
+	// Update updates existing checkouts to the latest revision
+	Update  bool
+	Verbose bool
+	// RemoteBase is the URL, or the local directory, which repositories
+	// at gopkgs.com are cloned from (set by get -remote). If empty,
+	// they're cloned from the API host (see GOPKGS_API_HOST).
+	RemoteBase string
+}
+
+// fetchRepo clones the repository at remote, which might be either a URL
+// or a local path, into dir. Each VCS in vcss is tried in order until
+// one of them succeeds. If rev is not empty, that revision is checked out
+// afterwards. If dir already contains a checkout, it's either updated to
+// the latest revision when opts.Update is true or, when rev is not empty,
+// rev is checked out, fetching it if required.
+func fetchRepo(w io.Writer, dir string, remote string, rev string, vcss []*vcs, opts *fetchOptions) error {
+	verbose := opts.Verbose
+	if v := vcsAt(dir); v != nil {
+		if rev != "" {
+			if verbose {
+				fmt.Fprintf(w, "checking out revision %s in %s\n", rev, dir)
+			}
+			return v.Checkout(dir, rev)
+		}
+		if opts.Update {
+			if verbose {
+				fmt.Fprintf(w, "updating %s\n", dir)
+			}
+			return v.Update(dir)
+		}
+		return nil
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists and is not a known VCS checkout", dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	var errs []string
+	for _, v := range vcss {
+		if verbose {
+			fmt.Fprintf(w, "cloning %s into %s using %s\n", remote, dir, v.Name)
+		}
+		if err := v.Clone(remote, dir); err != nil {
+			errs = append(errs, err.Error())
+			// Remove anything left by the failed clone
+			os.RemoveAll(dir)
+			continue
+		}
+		if rev != "" {
+			return v.Checkout(dir, rev)
+		}
+		return nil
+	}
+	return fmt.Errorf("can't clone %s: %s", remote, strings.Join(errs, "; "))
+}
+
+// remoteURL returns the URL for cloning the repository with the given
+// root import path. Repositories at gopkgs.com are cloned from
+// opts.RemoteBase or, by default, from the API host, so they're
+// downloaded from the same server used for resolving them.
+func remoteURL(root string, opts *fetchOptions) string {
+	if isGoPkgsImport(root) {
+		base := opts.RemoteBase
+		if base == "" {
+			base = getApiScheme() + "://" + getApiHost()
+		}
+		return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(root, lib.GoPkgsPrefix)
+	}
+	return "https://" + root
+}
+
+// cloneVCSs returns the VCSs which might be used for cloning the
+// repository with the given root import path, in the order they
+// should be tried.
+func cloneVCSs(root string) []*vcs {
+	if isGoPkgsImport(root) || strings.HasPrefix(root, lib.GitHubPrefix) {
+		// Both gopkgs.com and GitHub only serve git
+		return []*vcs{vcsGit}
+	}
+	var vcss []*vcs
+	for _, v := range vcsList {
+		if len(v.CloneArgs) > 0 {
+			vcss = append(vcss, v)
+		}
+	}
+	return vcss
+}
+
+// repoDir returns the directory where the repository with the given root
+// import path is checked out. If there's no checkout, the directory in the
+// first GOPATH entry is returned.
+func repoDir(root string) (string, error) {
+	if pkg, err := build.Import(root, "", build.FindOnly); err == nil && !pkg.Goroot {
+		return pkg.Dir, nil
+	}
+	goPaths := filepath.SplitList(build.Default.GOPATH)
+	if len(goPaths) == 0 {
+		return "", fmt.Errorf("GOPATH is not set")
+	}
+	return filepath.Join(goPaths[0], "src", filepath.FromSlash(root)), nil
+}
+
+var (
+	dirLocksMu sync.Mutex
+	dirLocks   = make(map[string]*sync.Mutex)
+)
+
+// lockDir acquires a lock for downloading into dir, so concurrent
+// downloads of the same repository don't step on each other. It
+// returns a function which releases the lock.
+func lockDir(dir string) func() {
+	dirLocksMu.Lock()
+	mu := dirLocks[dir]
+	if mu == nil {
+		mu = new(sync.Mutex)
+		dirLocks[dir] = mu
+	}
+	dirLocksMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// download fetches the repository containing the package with the given
+// import path into GOPATH, without its dependencies, checking out rev if
+// it's not empty. Packages from unknown hosts are downloaded using go get.
+func download(w io.Writer, importPath string, rev string, opts *fetchOptions) error {
+	root, _ := splitRepository(importPath)
+	if root == "" {
+		if err := goGet(w, importPath, opts.Update, opts.Verbose); err != nil {
+			return err
+		}
+		if rev == "" {
+			return nil
+		}
+		dir, v, err := packageVCSRoot(importPath)
+		if err != nil {
+			return err
+		}
+		return v.Checkout(dir, rev)
+	}
+	dir, err := repoDir(root)
+	if err != nil {
+		return err
+	}
+	defer lockDir(dir)()
+	return fetchRepo(w, dir, remoteURL(root, opts), rev, cloneVCSs(root), opts)
+}
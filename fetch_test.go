@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"go/build"
+	"path/filepath"
+	"testing"
+)
+
+// setGOPATH sets the GOPATH used for finding packages
+// until the test finishes.
+func setGOPATH(t *testing.T, gopath string) {
+	old := build.Default.GOPATH
+	build.Default.GOPATH = gopath
+	t.Cleanup(func() { build.Default.GOPATH = old })
+}
+
+func TestRemoteURL(t *testing.T) {
+	tests := []struct {
+		root string
+		base string
+		want string
+	}{
+		{"github.com/user/repo", "", "https://github.com/user/repo"},
+		{"gopkgs.com/vfs.v1", "/srv/mirrors", "/srv/mirrors/vfs.v1"},
+		{"gopkgs.com/gh/user/repo.v2", "file:///srv/mirrors/", "file:///srv/mirrors/gh/user/repo.v2"},
+	}
+	for _, v := range tests {
+		if got := remoteURL(v.root, &fetchOptions{RemoteBase: v.base}); got != v.want {
+			t.Errorf("remoteURL(%q) with base %q = %q, want %q", v.root, v.base, got, v.want)
+		}
+	}
+}
+
+func TestCloneVCSs(t *testing.T) {
+	for _, v := range []string{"github.com/user/repo", "gopkgs.com/vfs.v1"} {
+		if vcss := cloneVCSs(v); len(vcss) != 1 || vcss[0] != vcsGit {
+			t.Errorf("expecting %s to be cloned only with git", v)
+		}
+	}
+	if vcss := cloneVCSs("bitbucket.org/user/repo"); len(vcss) != 2 || vcss[0] != vcsGit || vcss[1] != vcsHg {
+		t.Error("expecting bitbucket.org repositories to be cloned with git, then hg")
+	}
+}
+
+func TestDownloadFromBareRepo(t *testing.T) {
+	bare, work := newBareRepo(t)
+	first := runGit(t, work, "rev-parse", "HEAD")
+	gopath := t.TempDir()
+	setGOPATH(t, gopath)
+	// git finds the bare repository even without the .git suffix
+	opts := &fetchOptions{RemoteBase: filepath.Dir(bare)}
+	const importPath = "gopkgs.com/repo/sub"
+	dir := filepath.Join(gopath, "src", "gopkgs.com", "repo")
+	head := func() string {
+		return runGit(t, dir, "rev-parse", "HEAD")
+	}
+	var buf bytes.Buffer
+	// Clone
+	if err := download(&buf, importPath, "", opts); err != nil {
+		t.Fatalf("error cloning: %s\n%s", err, buf.String())
+	}
+	if h := head(); h != first {
+		t.Errorf("expecting %s after cloning, got %s", first, h)
+	}
+	// Without -u, existing checkouts are left alone
+	second := commitFile(t, work, "b.go", "package a\n")
+	runGit(t, work, "push", "-q", "origin", "HEAD:master")
+	if err := download(&buf, importPath, "", opts); err != nil {
+		t.Fatal(err)
+	}
+	if h := head(); h != first {
+		t.Errorf("expecting %s without updating, got %s", first, h)
+	}
+	// Update to the latest revision
+	opts.Update = true
+	if err := download(&buf, importPath, "", opts); err != nil {
+		t.Fatalf("error updating: %s\n%s", err, buf.String())
+	}
+	if h := head(); h != second {
+		t.Errorf("expecting %s after updating, got %s", second, h)
+	}
+	opts.Update = false
+	// Check out an older revision, which is available locally
+	if err := download(&buf, importPath, first[:12], opts); err != nil {
+		t.Fatal(err)
+	}
+	if h := head(); h != first {
+		t.Errorf("expecting %s after checking it out, got %s", first, h)
+	}
+	// Check out a revision which must be fetched first
+	third := commitFile(t, work, "c.go", "package a\n")
+	runGit(t, work, "push", "-q", "origin", "HEAD:master")
+	if err := download(&buf, importPath, third, opts); err != nil {
+		t.Fatalf("error checking out new revision: %s\n%s", err, buf.String())
+	}
+	if h := head(); h != third {
+		t.Errorf("expecting %s after fetching it, got %s", third, h)
+	}
+}
+
+func TestDownloadCloneRevision(t *testing.T) {
+	bare, work := newBareRepo(t)
+	first := runGit(t, work, "rev-parse", "HEAD")
+	commitFile(t, work, "b.go", "package a\n")
+	runGit(t, work, "push", "-q", "origin", "HEAD:master")
+	gopath := t.TempDir()
+	setGOPATH(t, gopath)
+	opts := &fetchOptions{RemoteBase: "file://" + filepath.Dir(bare)}
+	var buf bytes.Buffer
+	if err := download(&buf, "gopkgs.com/repo", first, opts); err != nil {
+		t.Fatalf("error cloning: %s\n%s", err, buf.String())
+	}
+	dir := filepath.Join(gopath, "src", "gopkgs.com", "repo")
+	if h := runGit(t, dir, "rev-parse", "HEAD"); h != first {
+		t.Errorf("expecting %s after cloning, got %s", first, h)
+	}
+}
+
+func TestDownloadCloneError(t *testing.T) {
+	requireGit(t)
+	gopath := t.TempDir()
+	setGOPATH(t, gopath)
+	opts := &fetchOptions{RemoteBase: t.TempDir()}
+	var buf bytes.Buffer
+	if err := download(&buf, "gopkgs.com/missing", "", opts); err == nil {
+		t.Fatal("expecting an error when cloning a missing repository")
+	}
+	if vcsAt(filepath.Join(gopath, "src", "gopkgs.com", "missing")) != nil {
+		t.Error("failed clone left a checkout behind")
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func flagsCommand(args []string) error {
+	if len(args) > 0 {
+		return errors.New("flags doesn't accept any arguments")
+	}
+	fmt.Fprintln(stdout, globalFlagsHelp())
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+const (
+	lockFileName = "gopkgs.lock"
+)
+
+// lockRevisionRe matches the revisions accepted in lock files, either
+// commit hashes or, for svn and bzr, revision numbers.
+var lockRevisionRe = regexp.MustCompile(`^[0-9a-f]{1,40}$`)
+
+// lockFile is the format of the file written by freeze
+// and read by restore.
+type lockFile struct {
+	Repos []*lib.Repo `json:"repos"`
+}
+
+type freezeOptions struct {
+	Output  string `name:"o" help:"Lock file to write"`
+	Verbose bool   `name:"v" help:"Verbose output"`
+}
+
+type restoreOptions struct {
+	Input   string `name:"f" help:"Lock file to read"`
+	Verbose bool   `name:"v" help:"Verbose output"`
+}
+
+// thirdPartyRepositories returns the repositories used by the given packages
+// and their dependencies, which are not already imported from gopkgs.com.
+func thirdPartyRepositories(pkgs []*build.Package, verbose bool) []string {
+	repos := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(imports []string)
+	visit = func(imports []string) {
+		for _, imp := range imports {
+			if visited[imp] || imp == "C" || isGoPkgsImport(imp) {
+				continue
+			}
+			visited[imp] = true
+			if root, _ := splitRepository(imp); root != "" {
+				repos[root] = true
+			}
+			pkg, err := build.Import(imp, "", 0)
+			if err != nil {
+				if verbose {
+					fmt.Fprintf(os.Stderr, "can't import %s, ignoring its dependencies: %s\n", imp, err)
+				}
+				continue
+			}
+			if !pkg.Goroot {
+				visit(pkg.Imports)
+			}
+		}
+	}
+	for _, v := range pkgs {
+		visit(v.Imports)
+		visit(v.TestImports)
+		visit(v.XTestImports)
+	}
+	var names []string
+	for k := range repos {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func freezeCommand(args []string, opts *freezeOptions) error {
+	if len(args) == 0 {
+		args = []string{"./..."}
+	}
+	pkgs, err := importPackages(args)
+	if err != nil {
+		return err
+	}
+	names := thirdPartyRepositories(pkgs, opts.Verbose)
+	if len(names) == 0 {
+		return fmt.Errorf("no third party repositories found")
+	}
+	reqs := make([]*lib.RepoRequest, len(names))
+	for ii, v := range names {
+		rev, err := localRevision(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't determine local revision of %s, using latest: %s\n", v, err)
+		}
+		reqs[ii] = &lib.RepoRequest{Path: v, Revision: rev}
+	}
+	repos, err := Repos(reqs)
+	if err != nil {
+		return err
+	}
+	lock := &lockFile{}
+	for ii, v := range repos {
+		if v.Error != "" {
+			if reqs[ii].Revision == "" {
+				fmt.Fprintf(os.Stderr, "skipping %s: %s\n", names[ii], v.Error)
+				emit(&record{Command: "freeze", ImportPath: names[ii], Status: "skipped", Error: v.Error})
+				continue
+			}
+			// Not known by gopkgs.com, but we can still
+			// record the local revision.
+			fmt.Fprintf(os.Stderr, "%s: %s - recording local revision only\n", names[ii], v.Error)
+			rec := &record{Command: "freeze", ImportPath: names[ii], Status: "local revision only", Error: v.Error}
+			v = &lib.Repo{Path: names[ii], Revision: reqs[ii].Revision}
+			rec.Repo = v
+			emit(rec)
+		} else {
+			if rev := reqs[ii].Revision; rev != "" {
+				// The server only returns a prefix of the hash
+				v.Revision = rev
+			}
+			emit(&record{Command: "freeze", ImportPath: names[ii], Repo: v, Status: "frozen"})
+		}
+		if opts.Verbose {
+			fmt.Fprintf(stdout, "%s at revision %s\n", v.Path, v.Revision)
+		}
+		lock.Repos = append(lock.Repos, v)
+	}
+	data, err := json.MarshalIndent(lock, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(opts.Output, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "wrote %d repositories to %s\n", len(lock.Repos), opts.Output)
+	return nil
+}
+
+func restoreRepo(r *lib.Repo, opts *restoreOptions) error {
+	if r.Revision == "" {
+		return fmt.Errorf("no revision recorded for %s", r.Path)
+	}
+	if !lockRevisionRe.MatchString(r.Revision) {
+		return fmt.Errorf("invalid revision %q recorded for %s", r.Revision, r.Path)
+	}
+	if opts.Verbose {
+		fmt.Fprintf(stdout, "checking out %s at revision %s\n", r.Path, r.Revision)
+	}
+	return download(stdout, r.Path, r.Revision, &fetchOptions{Verbose: opts.Verbose})
+}
+
+func restoreCommand(args []string, opts *restoreOptions) error {
+	data, err := ioutil.ReadFile(opts.Input)
+	if err != nil {
+		return err
+	}
+	var lock lockFile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return fmt.Errorf("error decoding %s: %s", opts.Input, err)
+	}
+	failed := 0
+	for _, v := range lock.Repos {
+		rec := &record{Command: "restore", Repo: v, Status: "restored"}
+		if err := restoreRepo(v, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "error restoring %s: %s\n", v.Path, err)
+			rec.Status = "failed"
+			rec.SetError(err)
+			failed++
+		}
+		emit(rec)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d repositories could not be restored", failed, len(lock.Repos))
+	}
+	fmt.Fprintf(stdout, "restored %d repositories from %s\n", len(lock.Repos), opts.Input)
+	return nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+func TestRestoreInvalidRevision(t *testing.T) {
+	for _, v := range []string{"", "-b", "--upload-pack=touch x", "master", "0123456789abcdef0123456789abcdef012345678"} {
+		r := &lib.Repo{Path: "github.com/user/repo", Revision: v}
+		if err := restoreRepo(r, &restoreOptions{}); err == nil {
+			t.Errorf("expecting an error when restoring revision %q", v)
+		}
+	}
+}
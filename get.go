@@ -1,56 +1,96 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"go/build"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"gopkgs.com/cmd/gopkgs/lib"
 )
 
 type getOptions struct {
-	Update          bool `name:"u" help:"If a package is already downloaded, update it and its dependencies"`
-	PreferRevisions bool `name:"r" help:"Prefer revisions to versions"`
-	Verbose         bool `name:"v" help:"Verbose output"`
+	Update          bool   `name:"u" help:"If a package is already downloaded, update it"`
+	PreferRevisions bool   `name:"r" help:"Prefer revisions to versions"`
+	Verbose         bool   `name:"v" help:"Verbose output"`
+	Parallel        int    `name:"p" help:"Number of packages to download in parallel"`
+	Remote          string `name:"remote" help:"URL or directory to clone gopkgs.com repositories from, defaults to the API host"`
 }
 
-func runGoGet(r *lib.Repo, opts *getOptions) error {
-	args := []string{"get"}
-	if opts.Update {
+// goGet runs go get -d for the given import path, writing its output
+// to w. It's only used for packages from unknown hosts, which can't be
+// downloaded directly.
+func goGet(w io.Writer, importPath string, update bool, verbose bool) error {
+	args := []string{"get", "-d"}
+	if update {
 		args = append(args, "-u")
 	}
-	if opts.Verbose {
+	if verbose {
 		args = append(args, "-v")
 	}
+	args = append(args, importPath)
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = w
+	if w == stdout {
+		cmd.Stderr = os.Stderr
+	} else {
+		cmd.Stderr = w
+	}
+	return cmd.Run()
+}
+
+// getRepo downloads the repository for the package requested with the
+// given name, writing all the output to w. When using an import path
+// pinned on a revision, that exact revision is checked out.
+func getRepo(w io.Writer, name string, r *lib.Repo, opts *getOptions) error {
+	rec := &record{Command: "get", Repo: r}
 	var importPath string
-	if strings.HasPrefix(r.Path, lib.GoPkgsPrefix) || r.GoPkgsPath == "" {
-		// Package either was initially specified as a gopkgs.com import path,
-		// or unknown gopkgs.com
+	if imp, err := lib.ParseImportPath(name); (err == nil && imp.Pinned()) || r.GoPkgsPath == "" {
+		// Package either was initially specified as a pinned gopkgs.com
+		// import path, or unknown gopkgs.com
 		if r.Error != "" {
-			fmt.Printf("gopkgs can't find package %s: %s - using original\n", r.Path, r.Error)
+			fmt.Fprintf(w, "gopkgs can't find package %s: %s - using original\n", name, r.Error)
 		}
-		importPath = r.Path
+		importPath = name
 	} else {
 		if opts.PreferRevisions {
 			importPath = r.RevisionImportPath()
 		} else {
 			importPath = r.VersionImportPath()
 		}
-		fmt.Printf("using %s for package %s\n", importPath, r.Path)
+		fmt.Fprintf(w, "using %s for package %s\n", importPath, r.Path)
 	}
-	args = append(args, importPath)
-	cmd := exec.Command("go", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	rec.ImportPath = importPath
+	var rev string
+	if imp, err := lib.ParseImportPath(importPath); err == nil {
+		rev = imp.Revision
+	}
+	err := download(w, importPath, rev, &fetchOptions{Update: opts.Update, Verbose: opts.Verbose, RemoteBase: opts.Remote})
+	rec.SetError(err)
+	emit(rec)
+	return err
 }
 
-func listGoPkgsPackages() ([]string, error) {
-	var pkgs []string
+// localPackage is a gopkgs.com repository checked out in GOPATH.
+type localPackage struct {
+	// ImportPath is the import path of the repository root
+	ImportPath string
+	// Dir is the root directory of the checkout
+	Dir string
+	// VCS is the VCS used by the checkout
+	VCS *vcs
+}
+
+// listGoPkgsPackages returns the gopkgs.com repositories checked
+// out in every GOPATH entry, using any of the VCSs in vcsList.
+func listGoPkgsPackages() ([]*localPackage, error) {
+	var pkgs []*localPackage
 	for _, goPath := range filepath.SplitList(build.Default.GOPATH) {
 		abs, err := filepath.Abs(filepath.Join(goPath, "src"))
 		if err != nil {
@@ -61,15 +101,18 @@ func listGoPkgsPackages() ([]string, error) {
 			if err != nil || !info.IsDir() {
 				return err
 			}
-			dotGit := filepath.Join(s, ".git")
-			if st, err := os.Stat(dotGit); err == nil && st.IsDir() {
-				pkg := strings.TrimPrefix(s, trim)
-				// Ignore the gopkgs command
-				if pkg != "gopkgs.com/cmd/gopkgs" {
-					pkgs = append(pkgs, pkg)
-				}
+			v := vcsAt(s)
+			if v == nil {
+				return nil
+			}
+			pkg := filepath.ToSlash(strings.TrimPrefix(s, trim))
+			// Ignore the gopkgs command
+			if pkg != "gopkgs.com/cmd/gopkgs" {
+				pkgs = append(pkgs, &localPackage{ImportPath: pkg, Dir: s, VCS: v})
 			}
-			return nil
+			// Don't look for packages inside the checkout, nor
+			// inside its metadata directory.
+			return filepath.SkipDir
 		})
 	}
 	return pkgs, nil
@@ -79,12 +122,19 @@ func getCommand(args []string, opts *getOptions) error {
 	if len(args) == 0 {
 		if opts.Update {
 			// Gather all packages from gopkgs.com
-			args, _ = listGoPkgsPackages()
+			pkgs, _ := listGoPkgsPackages()
+			for _, v := range pkgs {
+				args = append(args, v.ImportPath)
+			}
 		}
 		if len(args) == 0 {
 			return errors.New("no packages specified")
 		}
 	}
+	args, err := expandImportPaths(args)
+	if err != nil {
+		return err
+	}
 	var reqs []*lib.RepoRequest
 	for _, v := range args {
 		reqs = append(reqs, &lib.RepoRequest{Path: v})
@@ -93,8 +143,44 @@ func getCommand(args []string, opts *getOptions) error {
 	if err != nil {
 		return err
 	}
-	for _, r := range repos {
-		runGoGet(r, opts)
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	errs := make([]error, len(repos))
+	sem := make(chan struct{}, parallel)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for ii, r := range repos {
+		wg.Add(1)
+		go func(ii int, r *lib.Repo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			var buf bytes.Buffer
+			err := getRepo(&buf, args[ii], r, opts)
+			errs[ii] = err
+			// Print all the output for each package at once, so
+			// the output from concurrent downloads is not mixed.
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(stdout, "# %s\n", args[ii])
+			stdout.Write(buf.Bytes())
+			if err != nil {
+				fmt.Fprintf(stdout, "error downloading %s: %s\n", args[ii], err)
+			}
+		}(ii, r)
+	}
+	wg.Wait()
+	var failed []string
+	for ii, v := range errs {
+		if v != nil {
+			failed = append(failed, args[ii])
+		}
+	}
+	fmt.Fprintf(stdout, "downloaded %d of %d packages\n", len(repos)-len(failed), len(repos))
+	if len(failed) > 0 {
+		return fmt.Errorf("%d packages failed to download: %s", len(failed), strings.Join(failed, ", "))
 	}
 	return nil
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+// setTestAPI makes the API requests go to a server which resolves
+// every repository using the given function, until the test finishes.
+// The cache is disabled while the server is used.
+func setTestAPI(t *testing.T, resolve func(req *lib.RepoRequest) *lib.Repo) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v"+lib.APIVersion+"/info", func(w http.ResponseWriter, r *http.Request) {
+		var reqs []*lib.RepoRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		repos := make([]*lib.Repo, len(reqs))
+		for ii, v := range reqs {
+			repos[ii] = resolve(v)
+		}
+		json.NewEncoder(w).Encode(repos)
+	})
+	srv := httptest.NewServer(mux)
+	oldClient, oldCache := apiClient, apiCache
+	apiClient, apiCache = &lib.Client{BaseURL: srv.URL}, nil
+	t.Cleanup(func() {
+		apiClient, apiCache = oldClient, oldCache
+		srv.Close()
+	})
+}
+
+// captureStdout captures the human readable output until
+// the test finishes.
+func captureStdout(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	old := stdout
+	stdout = &buf
+	t.Cleanup(func() {
+		stdout = old
+	})
+	return &buf
+}
+
+func TestGetRemote(t *testing.T) {
+	bare, work := newBareRepo(t)
+	gopath := t.TempDir()
+	setGOPATH(t, gopath)
+	var buf bytes.Buffer
+	opts := &getOptions{Remote: filepath.Dir(bare)}
+	if err := getRepo(&buf, "gopkgs.com/repo", &lib.Repo{}, opts); err != nil {
+		t.Fatalf("%s: %s", err, buf.String())
+	}
+	dir := filepath.Join(gopath, "src", "gopkgs.com", "repo")
+	if head, want := runGit(t, dir, "rev-parse", "HEAD"), runGit(t, work, "rev-parse", "HEAD"); head != want {
+		t.Errorf("expecting %s to be cloned from -remote at %s, got %s", dir, want, head)
+	}
+}
+
+func TestGetFailures(t *testing.T) {
+	bare, work := newBareRepo(t)
+	gopath := t.TempDir()
+	setGOPATH(t, gopath)
+	setTestAPI(t, func(req *lib.RepoRequest) *lib.Repo {
+		name := req.Path[strings.LastIndex(req.Path, "/")+1:]
+		return &lib.Repo{Path: req.Path, GoPkgsPath: lib.GoPkgsPrefix + name}
+	})
+	out := captureStdout(t)
+	var records bytes.Buffer
+	jsonEncoder = json.NewEncoder(&records)
+	defer func() {
+		jsonEncoder = nil
+	}()
+	// Only the repository named repo exists at -remote
+	opts := &getOptions{Parallel: 2, Remote: filepath.Dir(bare)}
+	err := getCommand([]string{"github.com/user/missing", "github.com/user/repo"}, opts)
+	if err == nil {
+		t.Fatal("expecting an error when a package can't be downloaded")
+	}
+	if want := "1 packages failed to download: github.com/user/missing"; err.Error() != want {
+		t.Errorf("expecting error %q, got %q", want, err)
+	}
+	output := out.String()
+	for _, v := range []string{
+		"# github.com/user/repo\nusing gopkgs.com/repo for package github.com/user/repo\n",
+		"# github.com/user/missing\nusing gopkgs.com/missing for package github.com/user/missing\n",
+		"error downloading github.com/user/missing: ",
+		"downloaded 1 of 2 packages\n",
+	} {
+		if !strings.Contains(output, v) {
+			t.Errorf("expecting %q in output:\n%s", v, output)
+		}
+	}
+	errs := make(map[string]string)
+	dec := json.NewDecoder(&records)
+	for {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			break
+		}
+		errs[rec.ImportPath] = rec.Error
+	}
+	if len(errs) != 2 || errs["gopkgs.com/repo"] != "" || errs["gopkgs.com/missing"] == "" {
+		t.Errorf("expecting a record for each package, with an error only for the missing one, got %v", errs)
+	}
+	dir := filepath.Join(gopath, "src", "gopkgs.com", "repo")
+	if head, want := runGit(t, dir, "rev-parse", "HEAD"), runGit(t, work, "rev-parse", "HEAD"); head != want {
+		t.Errorf("expecting %s to be downloaded at %s, got %s", dir, want, head)
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+// isGoPkgsImport returns true iff p is a valid gopkgs.com import path.
+func isGoPkgsImport(p string) bool {
+	_, err := lib.ParseImportPath(p)
+	return err == nil
+}
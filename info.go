@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+// repoInfo contains the values computed from a lib.Repo
+// which are shown by the info command.
+type repoInfo struct {
+	VersionImportPath     string `json:"version_import_path"`
+	RevisionImportPath    string `json:"revision_import_path"`
+	VersionDocumentation  string `json:"version_documentation"`
+	RevisionDocumentation string `json:"revision_documentation"`
+}
+
+func newRepoInfo(r *lib.Repo) *repoInfo {
+	return &repoInfo{
+		VersionImportPath:     r.VersionImportPath(),
+		RevisionImportPath:    r.RevisionImportPath(),
+		VersionDocumentation:  r.VersionDocumentation(),
+		RevisionDocumentation: r.RevisionDocumentation(),
+	}
+}
+
+func printRepoInfo(w *tabwriter.Writer, name string, r *lib.Repo) {
+	fmt.Fprintf(w, "%s\n", name)
+	if r.Error != "" {
+		fmt.Fprintf(w, "  error:\t%s\n", r.Error)
+		return
+	}
+	info := newRepoInfo(r)
+	version := "-"
+	if r.Version > 0 {
+		version = "v" + strconv.Itoa(r.Version)
+	}
+	revision := "-"
+	if r.Revision != "" {
+		revision = r.Revision
+	}
+	fmt.Fprintf(w, "  path:\t%s\n", r.Path)
+	fmt.Fprintf(w, "  gopkgs path:\t%s\n", r.GoPkgsPath)
+	fmt.Fprintf(w, "  latest version:\t%s\n", version)
+	fmt.Fprintf(w, "  latest revision:\t%s\n", revision)
+	fmt.Fprintf(w, "  allows unpinned:\t%v\n", r.AllowsUnpinned)
+	fmt.Fprintf(w, "  version import path:\t%s\n", info.VersionImportPath)
+	fmt.Fprintf(w, "  revision import path:\t%s\n", info.RevisionImportPath)
+	fmt.Fprintf(w, "  documentation prefix:\t%s\n", r.DocumentationPrefix)
+	fmt.Fprintf(w, "  version documentation:\t%s\n", info.VersionDocumentation)
+	fmt.Fprintf(w, "  revision documentation:\t%s\n", info.RevisionDocumentation)
+}
+
+func infoCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing package import path")
+	}
+	reqs := make([]*lib.RepoRequest, len(args))
+	for ii, v := range args {
+		reqs[ii] = &lib.RepoRequest{Path: v}
+	}
+	repos, err := Repos(reqs)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 8, 1, ' ', 0)
+	failed := 0
+	for ii, v := range repos {
+		if v.Error != "" {
+			failed++
+		}
+		if jsonOutput() {
+			rec := &record{Command: "info", ImportPath: args[ii], Repo: v, Error: v.Error}
+			if v.Error == "" {
+				rec.Info = newRepoInfo(v)
+			}
+			emit(rec)
+			continue
+		}
+		if ii > 0 {
+			fmt.Fprintln(w)
+		}
+		printRepoInfo(w, args[ii], v)
+	}
+	w.Flush()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d import paths couldn't be resolved", failed, len(repos))
+	}
+	return nil
+}
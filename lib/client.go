@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHost is the host used by clients which
+	// don't specify one.
+	DefaultHost = "gopkgs.com"
+	// DefaultScheme is the scheme used by clients which
+	// don't specify one.
+	DefaultScheme = "http"
+	// DefaultTimeout is the timeout used by clients which
+	// don't specify one.
+	DefaultTimeout = 30 * time.Second
+	// APIVersion is the API version used by the client.
+	APIVersion = "1"
+)
+
+// APIError is returned by Client when the API responds
+// with a non-200 status code.
+type APIError struct {
+	// URL is the URL which returned the error.
+	URL string
+	// StatusCode is the HTTP status code in the response.
+	StatusCode int
+	// Body contains the response body.
+	Body []byte
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d status code from %s: %s", e.StatusCode, e.URL, string(e.Body))
+}
+
+// Client is a client for the gopkgs.com API. The zero
+// Client is valid and uses the default host, scheme and
+// timeout with http.DefaultClient.
+type Client struct {
+	// Host is the host (and optional port) to connect to. If
+	// empty, DefaultHost is used.
+	Host string
+	// Scheme is the URL scheme used to connect to the API, either
+	// http or https. If empty, DefaultScheme is used.
+	Scheme string
+	// BaseURL is the URL of the server, optionally including a path
+	// prefix (e.g. https://example.com/gopkgs). If non-empty, it
+	// overrides both Host and Scheme.
+	BaseURL string
+	// HTTPClient is the client used to perform the requests. If
+	// nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Timeout is the maximum duration of each request. If zero,
+	// DefaultTimeout is used. Negative values disable the timeout.
+	Timeout time.Duration
+}
+
+// NewClient returns a new Client which connects to the given host.
+func NewClient(host string) *Client {
+	return &Client{Host: host}
+}
+
+// APIURL returns the base URL for the API, without a
+// trailing slash.
+func (c *Client) APIURL() string {
+	if c.BaseURL != "" {
+		return strings.TrimSuffix(c.BaseURL, "/") + "/api/v" + APIVersion
+	}
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = DefaultScheme
+	}
+	host := c.Host
+	if host == "" {
+		host = DefaultHost
+	}
+	return scheme + "://" + host + "/api/v" + APIVersion
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// Post sends the JSON encoded in to the given API path (e.g. /info)
+// and decodes the JSON response into out.
+func (c *Client) Post(ctx context.Context, path string, in interface{}, out interface{}) error {
+	postData, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	url := c.APIURL() + path
+	req, err := http.NewRequest("POST", url, bytes.NewReader(postData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{URL: url, StatusCode: resp.StatusCode, Body: data}
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("error decoding JSON: %s\nResponse:\n%s\n", err, string(data))
+	}
+	return nil
+}
+
+// Repos returns information about the given repositories. The returned
+// slice has the same length as reqs, with each element corresponding to
+// the request at the same index. Errors for individual repositories are
+// reported in Repo.Error.
+func (c *Client) Repos(ctx context.Context, reqs []*RepoRequest) ([]*Repo, error) {
+	var repos []*Repo
+	if err := c.Post(ctx, "/info", reqs, &repos); err != nil {
+		return nil, err
+	}
+	if len(repos) != len(reqs) {
+		return nil, fmt.Errorf("requested %d repositories, got %d", len(reqs), len(repos))
+	}
+	return repos, nil
+}
+
+// Repo is a shorthand for requesting a single repository with Repos. If
+// the returned Repo has a non-empty Error, it's returned as an error.
+func (c *Client) Repo(ctx context.Context, req *RepoRequest) (*Repo, error) {
+	repos, err := c.Repos(ctx, []*RepoRequest{req})
+	if err != nil {
+		return nil, err
+	}
+	if repos[0].Error != "" {
+		return nil, errors.New(repos[0].Error)
+	}
+	return repos[0], nil
+}
+
+// Versions returns the available versions and the latest revisions of
+// the given repositories. As in Repos, the returned slice has the same
+// length as reqs and errors for individual repositories are reported
+// in RepoVersions.Error.
+func (c *Client) Versions(ctx context.Context, reqs []*VersionsRequest) ([]*RepoVersions, error) {
+	var versions []*RepoVersions
+	if err := c.Post(ctx, "/versions", reqs, &versions); err != nil {
+		return nil, err
+	}
+	if len(versions) != len(reqs) {
+		return nil, fmt.Errorf("requested %d repositories, got %d", len(reqs), len(versions))
+	}
+	return versions, nil
+}
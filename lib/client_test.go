@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServer returns a server which answers the info endpoint
+// under the given path prefix, echoing the requested paths.
+func newTestServer(t *testing.T, prefix string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/api/v"+APIVersion+"/info", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		var reqs []*RepoRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		repos := make([]*Repo, len(reqs))
+		for ii, v := range reqs {
+			repos[ii] = &Repo{Path: v.Path, Revision: v.Revision}
+			if v.Path == "github.com/unknown/repo" {
+				repos[ii].Error = "unknown package"
+			}
+		}
+		json.NewEncoder(w).Encode(repos)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientAPIURL(t *testing.T) {
+	tests := []struct {
+		client *Client
+		want   string
+	}{
+		{&Client{}, "http://gopkgs.com/api/v" + APIVersion},
+		{&Client{Host: "localhost:8080", Scheme: "https"}, "https://localhost:8080/api/v" + APIVersion},
+		{&Client{Host: "ignored", BaseURL: "https://example.com/gopkgs/"}, "https://example.com/gopkgs/api/v" + APIVersion},
+	}
+	for _, v := range tests {
+		if got := v.client.APIURL(); got != v.want {
+			t.Errorf("APIURL() = %q, want %q", got, v.want)
+		}
+	}
+}
+
+func TestClientRepos(t *testing.T) {
+	srv := newTestServer(t, "/gopkgs")
+	c := &Client{BaseURL: srv.URL + "/gopkgs"}
+	ctx := context.Background()
+	reqs := []*RepoRequest{
+		{Path: "github.com/rainycape/vfs", Revision: "0123456789ab"},
+		{Path: "github.com/unknown/repo"},
+	}
+	repos, err := c.Repos(ctx, reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 || repos[0].Path != reqs[0].Path || repos[0].Revision != reqs[0].Revision || repos[1].Error == "" {
+		t.Errorf("unexpected repos %+v", repos)
+	}
+	if _, err := c.Repo(ctx, reqs[1]); err == nil || err.Error() != "unknown package" {
+		t.Errorf("expecting unknown package error, got %v", err)
+	}
+}
+
+func TestClientAPIError(t *testing.T) {
+	srv := newTestServer(t, "/gopkgs")
+	c := &Client{BaseURL: srv.URL}
+	_, err := c.Repos(context.Background(), []*RepoRequest{{Path: "github.com/rainycape/vfs"}})
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("expecting an *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.URL != srv.URL+"/api/v"+APIVersion+"/info" {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+}
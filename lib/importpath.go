@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var (
+	importPathRe = regexp.MustCompile(`^` + GoPkgsPattern + `(?P<subpackage>/.*)?$`)
+	// gopkgsRepoRe splits the repository matched by GoPkgsPattern into
+	// the host shortcut, the repository name, the version and the
+	// revision. Note that the name is matched lazily, so the version
+	// or revision suffix is never included in it. Revisions must match
+	// RevisionPattern, otherwise the suffix is part of the name (e.g.
+	// gopkgs.com/foo.rad).
+	gopkgsRepoRe = regexp.MustCompile(`^(?:(` + GoPkgsGitHubPrefix + `|` + GoPkgsBitBucketPrefix + `)/([A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+?)|(` + GoPkgsGoogleCodePrefix + `)/([A-Za-z0-9_.\-]+?)|([A-Za-z0-9_.\-]+?))(?:\.v([0-9]+)|\.r(` + RevisionPattern + `))?$`)
+)
+
+// ImportPath represents a parsed gopkgs.com import path, like
+// gopkgs.com/gh/user/repo.v2/subpkg or gopkgs.com/vfs.r1a2b3c.
+// Use ParseImportPath to obtain an ImportPath.
+type ImportPath struct {
+	// Host is the shortcut for the host of the original repository
+	// (GoPkgsGitHubPrefix, GoPkgsGoogleCodePrefix or GoPkgsBitBucketPrefix),
+	// or empty for repositories named directly at gopkgs.com.
+	Host string
+	// Repository is the repository name relative to the host (e.g.
+	// user/repo for GitHub and BitBucket, vfs for gopkgs.com/vfs).
+	Repository string
+	// Version is the pinned version, or 0 if the import
+	// path is not pinned on a version.
+	Version int
+	// Revision is the pinned revision, or empty if the
+	// import path is not pinned on a revision.
+	Revision string
+	// Subpackage is the path of the package relative to the
+	// repository root, including the leading slash, or empty
+	// if the import path points to the root.
+	Subpackage string
+}
+
+// ParseImportPath parses the given gopkgs.com import path. If p
+// is not a gopkgs.com import path, an error is returned.
+func ParseImportPath(p string) (*ImportPath, error) {
+	m := importPathRe.FindStringSubmatch(p)
+	if m == nil {
+		return nil, fmt.Errorf("%s is not a gopkgs.com import path", p)
+	}
+	var repo, subpackage string
+	for ii, name := range importPathRe.SubexpNames() {
+		switch name {
+		case "gopkgs_repo":
+			repo = m[ii]
+		case "subpackage":
+			subpackage = m[ii]
+		}
+	}
+	rm := gopkgsRepoRe.FindStringSubmatch(repo)
+	if rm == nil {
+		return nil, fmt.Errorf("%s is not a valid gopkgs.com import path", p)
+	}
+	imp := &ImportPath{
+		Revision:   rm[7],
+		Subpackage: subpackage,
+	}
+	switch {
+	case rm[1] != "":
+		imp.Host = rm[1]
+		imp.Repository = rm[2]
+	case rm[3] != "":
+		imp.Host = rm[3]
+		imp.Repository = rm[4]
+	default:
+		imp.Repository = rm[5]
+		switch imp.Repository {
+		case GoPkgsGitHubPrefix, GoPkgsGoogleCodePrefix, GoPkgsBitBucketPrefix:
+			// e.g. gopkgs.com/gh/user, which would otherwise be
+			// parsed as the user subpackage of the gh repository.
+			return nil, fmt.Errorf("incomplete repository path in import path %s", p)
+		}
+	}
+	if rm[6] != "" {
+		// Versions with leading zeros are rejected, since they
+		// would be formatted differently by String.
+		v, err := strconv.Atoi(rm[6])
+		if err != nil || v == 0 || rm[6][0] == '0' {
+			return nil, fmt.Errorf("invalid version in import path %s", p)
+		}
+		imp.Version = v
+	}
+	return imp, nil
+}
+
+// Name returns the name of the repository at gopkgs.com, including
+// the host shortcut (e.g. gh/user/repo or vfs).
+func (p *ImportPath) Name() string {
+	if p.Host != "" {
+		return p.Host + "/" + p.Repository
+	}
+	return p.Repository
+}
+
+// Base returns the import path for the repository root, without
+// the version or revision (e.g. gopkgs.com/vfs).
+func (p *ImportPath) Base() string {
+	return GoPkgsPrefix + p.Name()
+}
+
+// Pinned returns true iff the import path is pinned
+// on either a version or a revision.
+func (p *ImportPath) Pinned() bool {
+	return p.Version > 0 || p.Revision != ""
+}
+
+// Pin returns the version or revision suffix of the import
+// path (e.g. v1 or r1a2b3c), or an empty string if the
+// import path is not pinned.
+func (p *ImportPath) Pin() string {
+	if p.Version > 0 {
+		return "v" + strconv.Itoa(p.Version)
+	}
+	if p.Revision != "" {
+		return "r" + p.Revision
+	}
+	return ""
+}
+
+// Root returns the import path for the repository root, including
+// the version or revision (e.g. gopkgs.com/vfs.v1).
+func (p *ImportPath) Root() string {
+	if pin := p.Pin(); pin != "" {
+		return p.Base() + "." + pin
+	}
+	return p.Base()
+}
+
+// OriginalPath returns the import path for the root of the original
+// repository, if it can be determined from the host shortcut (e.g.
+// github.com/user/repo for gopkgs.com/gh/user/repo). Otherwise, an
+// empty string is returned.
+func (p *ImportPath) OriginalPath() string {
+	switch p.Host {
+	case GoPkgsGitHubPrefix:
+		return GitHubPrefix + p.Repository
+	case GoPkgsGoogleCodePrefix:
+		return GoogleCodePrefix + p.Repository
+	case GoPkgsBitBucketPrefix:
+		return BitBucketPrefix + p.Repository
+	}
+	return ""
+}
+
+// String returns the import path. Parsing the returned
+// string yields an ImportPath equal to p.
+func (p *ImportPath) String() string {
+	return p.Root() + p.Subpackage
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestParseImportPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want ImportPath
+	}{
+		{"gopkgs.com/vfs", ImportPath{Repository: "vfs"}},
+		{"gopkgs.com/vfs.v1", ImportPath{Repository: "vfs", Version: 1}},
+		{"gopkgs.com/vfs.v10/sub/pkg", ImportPath{Repository: "vfs", Version: 10, Subpackage: "/sub/pkg"}},
+		{"gopkgs.com/vfs.r0123456789ab", ImportPath{Repository: "vfs", Revision: "0123456789ab"}},
+		{"gopkgs.com/vfs.r1a2b3c", ImportPath{Repository: "vfs", Revision: "1a2b3c"}},
+		{"gopkgs.com/vfs.r0123456789abc/sub", ImportPath{Repository: "vfs", Revision: "0123456789abc", Subpackage: "/sub"}},
+		{"gopkgs.com/vfs.r0123456789abcdef0123456789abcdef01234567/x", ImportPath{Repository: "vfs", Revision: "0123456789abcdef0123456789abcdef01234567", Subpackage: "/x"}},
+		{"gopkgs.com/gh/user/repo", ImportPath{Host: "gh", Repository: "user/repo"}},
+		{"gopkgs.com/gh/user/repo.v2/sub", ImportPath{Host: "gh", Repository: "user/repo", Version: 2, Subpackage: "/sub"}},
+		{"gopkgs.com/gh/user/repo.go.v1", ImportPath{Host: "gh", Repository: "user/repo.go", Version: 1}},
+		{"gopkgs.com/bb/user/repo.r0123456789ab", ImportPath{Host: "bb", Repository: "user/repo", Revision: "0123456789ab"}},
+		{"gopkgs.com/gc/project.v3/sub", ImportPath{Host: "gc", Repository: "project", Version: 3, Subpackage: "/sub"}},
+		// Suffixes which are not valid revisions are part of the name
+		{"gopkgs.com/foo.rad", ImportPath{Repository: "foo.rad"}},
+		{"gopkgs.com/foo.r012", ImportPath{Repository: "foo.r012"}},
+		{"gopkgs.com/foo.r0123456789abcdef0123456789abcdef012345678", ImportPath{Repository: "foo.r0123456789abcdef0123456789abcdef012345678"}},
+	}
+	for _, tt := range tests {
+		imp, err := ParseImportPath(tt.path)
+		if err != nil {
+			t.Errorf("ParseImportPath(%q) returned error %s", tt.path, err)
+			continue
+		}
+		if *imp != tt.want {
+			t.Errorf("ParseImportPath(%q) = %+v, want %+v", tt.path, *imp, tt.want)
+		}
+		if s := imp.String(); s != tt.path {
+			t.Errorf("ParseImportPath(%q).String() = %q, want %q", tt.path, s, tt.path)
+		}
+	}
+}
+
+func TestParseImportPathErrors(t *testing.T) {
+	tests := []string{
+		"github.com/user/repo",
+		"gopkgs.com/",
+		"gopkgs.com/vfs.v0",
+		"gopkgs.com/vfs.v01/x",
+		"gopkgs.com/gh/user",
+		"gopkgs.com/bb/user/",
+		"gopkgs.com/gc",
+	}
+	for _, v := range tests {
+		if imp, err := ParseImportPath(v); err == nil {
+			t.Errorf("ParseImportPath(%q) = %+v, want error", v, *imp)
+		}
+	}
+}
+
+func TestImportPathMethods(t *testing.T) {
+	imp, err := ParseImportPath("gopkgs.com/gh/user/repo.v2/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name, got, want string
+	}{
+		{"Name", imp.Name(), "gh/user/repo"},
+		{"Base", imp.Base(), "gopkgs.com/gh/user/repo"},
+		{"Pin", imp.Pin(), "v2"},
+		{"Root", imp.Root(), "gopkgs.com/gh/user/repo.v2"},
+		{"OriginalPath", imp.OriginalPath(), "github.com/user/repo"},
+	}
+	for _, v := range checks {
+		if v.got != v.want {
+			t.Errorf("%s() = %q, want %q", v.name, v.got, v.want)
+		}
+	}
+	if !imp.Pinned() {
+		t.Error("expecting import path to be pinned")
+	}
+}
@@ -1,7 +1,12 @@
 // Package lib contains types and constants used by both the gopkgs command and site.
 package lib
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
 
 const (
 	GitHubShortcut     = "gh"
@@ -10,17 +15,46 @@ const (
 
 	GitHubPrefix     = `github.com/`
 	GoogleCodePrefix = `code.google.com/p/`
+	BitBucketPrefix  = `bitbucket.org/`
 	GoPkgsPrefix     = `gopkgs.com/`
 
 	GoPkgsGitHubPrefix     = "gh"
 	GoPkgsGoogleCodePrefix = "gc"
+	GoPkgsBitBucketPrefix  = "bb"
+
+	// RevisionLength is the number of characters of each commit
+	// hash used in the revision import paths returned by the API.
+	// Import paths pinned on shorter prefixes, down to
+	// MinRevisionLength, or on a full commit hash are also accepted.
+	RevisionLength = 12
+	// MinRevisionLength is the minimum number of characters of the
+	// revisions accepted in import paths and API requests.
+	MinRevisionLength = 4
 
 	GitHubPattern     = `github\.com/(?P<github_repo>[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)`
-	GoogleCodePattern = `code.google.com/p/(?P<google_repo>[A-Za-z0-9\-]+(?:\.[A-Za-z0-9]+)?)`
+	GoogleCodePattern = `code\.google\.com/p/(?P<google_repo>[A-Za-z0-9\-]+(?:\.[A-Za-z0-9]+)?)`
+	BitBucketPattern  = `bitbucket\.org/(?P<bitbucket_repo>[A-Za-z0-9_.\-]+/[A-Za-z0-9_.\-]+)`
+
+	// GoPkgsPattern matches the repository root of gopkgs.com import paths,
+	// including the host shortcut and the version or revision suffix (e.g.
+	// gopkgs.com/gh/user/repo.v2). Use ParseImportPath to split it.
+	GoPkgsPattern = `gopkgs\.com/(?P<gopkgs_repo>(?:(?:gh|bb)/[A-Za-z0-9_.\-]+/|gc/)?[A-Za-z0-9_.\-]+)`
+)
+
+var (
+	// RevisionPattern matches the revisions accepted in import paths
+	// and API requests: hexadecimal commit hash prefixes between
+	// MinRevisionLength and 40 characters long.
+	RevisionPattern = `[0-9a-f]{` + strconv.Itoa(MinRevisionLength) + `,40}`
 
-	GoPkgsPattern = `gopkgs.com/(?P<gopkgs_repo>[A-Za-z0-9]+)`
+	revisionRe = regexp.MustCompile(`^` + RevisionPattern + `$`)
 )
 
+// ValidRevision returns true iff rev matches RevisionPattern.
+func ValidRevision(rev string) bool {
+	return revisionRe.MatchString(rev)
+}
+
 type RepoRequest struct {
 	Path     string `json:"path"`
 	Revision string `json:"revision"`
@@ -43,6 +77,15 @@ func (r *Repo) VersionImportPath() string {
 	return r.RevisionImportPath()
 }
 
+// ShortRevision returns the prefix of the given commit hash
+// used in revision import paths (see RevisionLength).
+func ShortRevision(commit string) string {
+	if len(commit) > RevisionLength {
+		return commit[:RevisionLength]
+	}
+	return commit
+}
+
 func (r *Repo) RevisionImportPath() string {
 	if r.Revision != "" {
 		return fmt.Sprintf("%s.r%s", r.GoPkgsPath, r.Revision)
@@ -57,3 +100,57 @@ func (r *Repo) VersionDocumentation() string {
 func (r *Repo) RevisionDocumentation() string {
 	return r.DocumentationPrefix + r.RevisionImportPath()
 }
+
+// VersionsRequest requests the version history of a repository.
+type VersionsRequest struct {
+	Path string `json:"path"`
+	// Revisions is the maximum number of latest revisions
+	// to return. If zero, the server chooses the number.
+	Revisions int `json:"revisions"`
+}
+
+// Version represents a version available for a repository.
+type Version struct {
+	Number int `json:"number"`
+	// Tag is the name of the tag or branch which
+	// provides the version (e.g. v1 or v1.2.3).
+	Tag    string    `json:"tag"`
+	Commit string    `json:"commit"`
+	Date   time.Time `json:"date"`
+	// Major is true if the version import path for Number
+	// (e.g. gopkgs.com/vfs.v1) resolves to this version.
+	Major bool `json:"major"`
+}
+
+// Revision represents a commit in a repository.
+type Revision struct {
+	Commit  string    `json:"commit"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// RepoVersions contains all the versions available for a
+// repository, sorted by their numeric components (e.g. v1,
+// v1.2, v1.10, v2), and its latest revisions, most recent
+// first.
+type RepoVersions struct {
+	Path       string      `json:"path"`
+	GoPkgsPath string      `json:"gopkgs_path"`
+	Versions   []*Version  `json:"versions"`
+	Revisions  []*Revision `json:"revisions"`
+	Error      string      `json:"error"`
+}
+
+// VersionImportPath returns the import path which pins the given
+// version. Versions which are not the Major one for their number
+// can only be pinned by revision.
+func (r *RepoVersions) VersionImportPath(v *Version) string {
+	if !v.Major {
+		return fmt.Sprintf("%s.r%s", r.GoPkgsPath, v.Commit)
+	}
+	return fmt.Sprintf("%s.v%d", r.GoPkgsPath, v.Number)
+}
+
+func (r *RepoVersions) RevisionImportPath(rev *Revision) string {
+	return fmt.Sprintf("%s.r%s", r.GoPkgsPath, rev.Commit)
+}
@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"testing"
+)
+
+func TestShortRevision(t *testing.T) {
+	tests := []struct {
+		commit string
+		want   string
+	}{
+		{"0123456789abcdef0123456789abcdef01234567", "0123456789ab"},
+		{"0123456789ab", "0123456789ab"},
+		{"1234", "1234"},
+		{"", ""},
+	}
+	for _, v := range tests {
+		if got := ShortRevision(v.commit); got != v.want {
+			t.Errorf("ShortRevision(%q) = %q, want %q", v.commit, got, v.want)
+		}
+	}
+}
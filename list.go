@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+// checkoutInfo describes the state of a local checkout
+// of a gopkgs.com repository.
+type checkoutInfo struct {
+	Dir      string `json:"dir"`
+	VCS      string `json:"vcs"`
+	Revision string `json:"revision"`
+	Dirty    bool   `json:"dirty"`
+	// Unpushed is true if the repository has commits, branches
+	// or stashes which are not present in its default remote.
+	Unpushed bool `json:"unpushed,omitempty"`
+	// Upstream is the import path of the original repository
+	Upstream string `json:"upstream,omitempty"`
+}
+
+// isCheckoutOutdated returns true iff there's a newer version or
+// revision than the one the checkout of the given import path is
+// pinned on or, for unpinned import paths, than the one which is
+// checked out.
+func isCheckoutOutdated(imp *lib.ImportPath, co *checkoutInfo, repo *lib.Repo) bool {
+	if imp.Pinned() {
+		return isOutdated(imp, repo)
+	}
+	if co.Revision != "" && repo.Revision != "" {
+		return !strings.HasPrefix(co.Revision, repo.Revision)
+	}
+	return false
+}
+
+// latestPin returns the version or revision suffix which the
+// given import path would be pinned on if it was updated.
+func latestPin(imp *lib.ImportPath, repo *lib.Repo) string {
+	if imp.Version > 0 && repo.Version > 0 {
+		return "v" + strconv.Itoa(repo.Version)
+	}
+	if repo.Revision != "" {
+		return "r" + repo.Revision
+	}
+	return "-"
+}
+
+func listCommand(args []string) error {
+	if len(args) > 0 {
+		return errors.New("list doesn't accept any arguments")
+	}
+	pkgs, err := listGoPkgsPackages()
+	if err != nil {
+		return err
+	}
+	if len(pkgs) == 0 {
+		fmt.Fprintln(stdout, "no gopkgs.com packages found in GOPATH")
+		return nil
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].ImportPath < pkgs[j].ImportPath })
+	imps := make([]*lib.ImportPath, len(pkgs))
+	var reqs []*lib.RepoRequest
+	var indexes []int
+	for ii, v := range pkgs {
+		imp, err := lib.ParseImportPath(v.ImportPath)
+		if err != nil {
+			log.Printf("error parsing %s: %s", v.ImportPath, err)
+			continue
+		}
+		imps[ii] = imp
+		reqs = append(reqs, &lib.RepoRequest{Path: imp.Base()})
+		indexes = append(indexes, ii)
+	}
+	repos := make([]*lib.Repo, len(pkgs))
+	if len(reqs) > 0 {
+		// The local state is still useful without the upstream
+		// one, so don't fail if gopkgs.com can't be reached.
+		resp, err := Repos(reqs)
+		if err != nil {
+			log.Printf("can't check for newer versions: %s", err)
+		} else {
+			for ii, v := range resp {
+				repos[indexes[ii]] = v
+			}
+		}
+	}
+	var rows []string
+	failed := 0
+	for ii, v := range pkgs {
+		rec := &record{Command: "list", ImportPath: v.ImportPath}
+		co := &checkoutInfo{Dir: v.Dir, VCS: v.VCS.Name}
+		rec.Checkout = co
+		state := "-"
+		var err error
+		if co.Revision, err = v.VCS.Revision(v.Dir); err != nil {
+			log.Printf("error reading revision of %s: %s", v.ImportPath, err)
+		}
+		if co.Dirty, err = v.VCS.Dirty(v.Dir); err != nil {
+			log.Printf("error reading status of %s: %s", v.ImportPath, err)
+		} else if co.Dirty {
+			state = "dirty"
+		} else {
+			state = "clean"
+		}
+		imp := imps[ii]
+		repo := repos[ii]
+		latest := "-"
+		switch {
+		case imp == nil:
+			rec.Status = "error"
+			rec.Error = "invalid gopkgs.com import path"
+			failed++
+		case repo == nil:
+			co.Upstream = imp.OriginalPath()
+			rec.Status = "unknown"
+		case repo.Error != "":
+			co.Upstream = imp.OriginalPath()
+			rec.Status = "error"
+			rec.Error = repo.Error
+			failed++
+		default:
+			rec.Repo = repo
+			co.Upstream = repo.Path
+			latest = latestPin(imp, repo)
+			if isCheckoutOutdated(imp, co, repo) {
+				rec.Status = "outdated"
+			} else {
+				rec.Status = "up to date"
+			}
+		}
+		emit(rec)
+		revision := "-"
+		if co.Revision != "" {
+			revision = lib.ShortRevision(co.Revision)
+		}
+		upstream := "-"
+		if co.Upstream != "" {
+			upstream = co.Upstream
+		}
+		status := rec.Status
+		if rec.Error != "" {
+			status = "error: " + rec.Error
+		}
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", v.ImportPath, co.VCS, revision, state, upstream, latest, status))
+	}
+	if !jsonOutput() {
+		w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "PACKAGE\tVCS\tREVISION\tSTATE\tUPSTREAM\tLATEST\tSTATUS")
+		for _, v := range rows {
+			fmt.Fprintln(w, v)
+		}
+		w.Flush()
+	}
+	if failed > 0 {
+		return fmt.Errorf("couldn't check %d packages", failed)
+	}
+	return nil
+}
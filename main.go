@@ -1,16 +1,192 @@
 package main
 
 import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
 	"regexp"
+	"strings"
+	"time"
 
 	"gopkgs.com/cmd/gopkgs/lib"
 	"gopkgs.com/command.v1"
 )
 
 var (
-	repositoryRe = regexp.MustCompile("^(?:" + lib.GitHubPattern + "|" + lib.GoogleCodePattern + "|" + lib.GoPkgsPattern + ")")
+	repositoryRe = regexp.MustCompile("^(?:" + lib.GitHubPattern + "|" + lib.GoogleCodePattern + "|" + lib.BitBucketPattern + "|" + lib.GoPkgsPattern + ")")
 )
 
+// splitRepository splits the given import path into the import path of its
+// repository root and the path of the package relative to it, including the
+// leading slash (or empty for the root package). The root must end at a path
+// separator, so github.com/user/repository is never considered to be inside
+// github.com/user/repo. If p is not in a known repository, root is empty.
+func splitRepository(p string) (root string, subpackage string) {
+	m := repositoryRe.FindStringSubmatchIndex(p)
+	if m == nil {
+		return "", ""
+	}
+	end := -1
+	for ii, name := range repositoryRe.SubexpNames() {
+		if strings.HasSuffix(name, "_repo") && m[2*ii+1] >= 0 {
+			end = m[2*ii+1]
+			break
+		}
+	}
+	if end < 0 || (end < len(p) && p[end] != '/') {
+		return "", ""
+	}
+	return p[:end], p[end:]
+}
+
+type globalOptions struct {
+	Offline  bool
+	CacheTTL time.Duration
+	JSON     bool
+}
+
+var (
+	globalOpts = globalOptions{
+		CacheTTL: defaultCacheTTL,
+	}
+)
+
+// globalFlagSet returns a flag set which parses the
+// global flags into globalOpts.
+func globalFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.BoolVar(&globalOpts.Offline, "offline", globalOpts.Offline, "Only use cached responses from gopkgs.com")
+	fs.DurationVar(&globalOpts.CacheTTL, "cache-ttl", globalOpts.CacheTTL, "Maximum age of cached responses from gopkgs.com, 0 disables the cache")
+	fs.BoolVar(&globalOpts.JSON, "json", globalOpts.JSON, "Write machine readable records to stdout, one JSON object per line")
+	return fs
+}
+
+// globalFlagsHelp returns the help for the global flags,
+// which is included in the help for every command.
+func globalFlagsHelp() string {
+	var buf bytes.Buffer
+	buf.WriteString("Global flags, which might appear either before the command name or among its flags:\n")
+	fs := globalFlagSet()
+	fs.SetOutput(&buf)
+	fs.PrintDefaults()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// commandFlags returns the flags accepted by the command with the given
+// name, mapped to whether they take a value (i.e. they're not booleans).
+// If there's no such command, it returns nil.
+func commandFlags(name string) map[string]bool {
+	for _, cmd := range commands {
+		if cmd.Name != name {
+			continue
+		}
+		flags := make(map[string]bool)
+		if cmd.Options == nil {
+			return flags
+		}
+		typ := reflect.TypeOf(cmd.Options).Elem()
+		boolFlag := reflect.TypeOf((*interface{ IsBoolFlag() bool })(nil)).Elem()
+		for ii := 0; ii < typ.NumField(); ii++ {
+			field := typ.Field(ii)
+			name := field.Tag.Get("name")
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			flags[name] = field.Type.Kind() != reflect.Bool && !reflect.PtrTo(field.Type).Implements(boolFlag)
+		}
+		return flags
+	}
+	return nil
+}
+
+// parseGlobalFlags parses the global flags, which might appear either
+// before the command name or among the command flags (e.g. gopkgs
+// -offline get ... or gopkgs get -u -offline ...), and removes them
+// from os.Args, so they're not seen by the command. Flags declared by
+// the command itself, their values and everything from the first
+// argument after the command flags or -- on are left untouched.
+func parseGlobalFlags() error {
+	fs := globalFlagSet()
+	in := os.Args[1:]
+	var args []string
+	// cmdFlags is nil until the command name is found
+	var cmdFlags map[string]bool
+	for ii := 0; ii < len(in); ii++ {
+		arg := in[ii]
+		if arg == "--" {
+			args = append(args, in[ii:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if cmdFlags != nil {
+				args = append(args, in[ii:]...)
+				break
+			}
+			if cmdFlags = commandFlags(arg); cmdFlags == nil {
+				cmdFlags = make(map[string]bool)
+			}
+			args = append(args, arg)
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		value := ""
+		hasValue := false
+		if p := strings.IndexByte(name, '='); p >= 0 {
+			name, value, hasValue = name[:p], name[p+1:], true
+		}
+		if takesValue, ok := cmdFlags[name]; ok {
+			args = append(args, arg)
+			if takesValue && !hasValue && ii+1 < len(in) {
+				ii++
+				args = append(args, in[ii])
+			}
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			args = append(args, arg)
+			continue
+		}
+		if !hasValue {
+			if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+				value = "true"
+			} else if ii+1 < len(in) {
+				ii++
+				value = in[ii]
+			} else {
+				return fmt.Errorf("flag needs an argument: -%s", name)
+			}
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for flag -%s: %s", value, name, err)
+		}
+	}
+	os.Args = append(os.Args[:1], args...)
+	return nil
+}
+
+func init() {
+	help := globalFlagsHelp()
+	for _, v := range commands {
+		if v.Name != "flags" {
+			v.LongHelp += "\n\n" + help
+		}
+		v.Func = emitErrors(v.Name, v.Func)
+	}
+}
+
 func main() {
+	if err := parseGlobalFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if globalOpts.JSON {
+		enableJSONOutput()
+	}
+	openCache()
 	command.Run(commands)
 }
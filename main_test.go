@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGlobalFlags(t *testing.T) {
+	oldArgs, oldOpts := os.Args, globalOpts
+	defer func() {
+		os.Args, globalOpts = oldArgs, oldOpts
+	}()
+	tests := []struct {
+		args []string
+		want []string
+		opts globalOptions
+	}{
+		{[]string{"-offline", "list"}, []string{"list"}, globalOptions{Offline: true, CacheTTL: defaultCacheTTL}},
+		{[]string{"list", "-json"}, []string{"list"}, globalOptions{JSON: true, CacheTTL: defaultCacheTTL}},
+		{[]string{"get", "-u", "--cache-ttl", "5m", "gopkgs.com/vfs.v1"}, []string{"get", "-u", "gopkgs.com/vfs.v1"}, globalOptions{CacheTTL: 5 * time.Minute}},
+		{[]string{"-cache-ttl=0", "info", "-json=false", "x"}, []string{"info", "x"}, globalOptions{}},
+		{[]string{"rewrite", "-n", "--", "-json"}, []string{"rewrite", "-n", "--", "-json"}, globalOptions{CacheTTL: defaultCacheTTL}},
+		{[]string{"-h"}, []string{"-h"}, globalOptions{CacheTTL: defaultCacheTTL}},
+		// Values of command flags and positional arguments equal
+		// to the name of a global flag are left untouched
+		{[]string{"upgrade", "-repo", "-json", "-offline"}, []string{"upgrade", "-repo", "-json"}, globalOptions{Offline: true, CacheTTL: defaultCacheTTL}},
+		{[]string{"-json", "upgrade", "-n", "-repo=-offline", "-version", "2", "."}, []string{"upgrade", "-n", "-repo=-offline", "-version", "2", "."}, globalOptions{JSON: true, CacheTTL: defaultCacheTTL}},
+		{[]string{"rewrite", "./...", "-json", "-offline"}, []string{"rewrite", "./...", "-json", "-offline"}, globalOptions{CacheTTL: defaultCacheTTL}},
+		{[]string{"get", "-p", "4", "-offline", "gopkgs.com/vfs.v1", "-json"}, []string{"get", "-p", "4", "gopkgs.com/vfs.v1", "-json"}, globalOptions{Offline: true, CacheTTL: defaultCacheTTL}},
+	}
+	for _, v := range tests {
+		globalOpts = globalOptions{CacheTTL: defaultCacheTTL}
+		os.Args = append([]string{"gopkgs"}, v.args...)
+		if err := parseGlobalFlags(); err != nil {
+			t.Errorf("parsing %q: %s", v.args, err)
+			continue
+		}
+		if got := os.Args[1:]; !reflect.DeepEqual(got, v.want) {
+			t.Errorf("parsing %q left %q, want %q", v.args, got, v.want)
+		}
+		if globalOpts != v.opts {
+			t.Errorf("parsing %q = %+v, want %+v", v.args, globalOpts, v.opts)
+		}
+	}
+	for _, v := range [][]string{{"list", "-cache-ttl"}, {"-cache-ttl", "soon", "list"}, {"list", "-json=maybe"}} {
+		os.Args = append([]string{"gopkgs"}, v...)
+		if err := parseGlobalFlags(); err == nil {
+			t.Errorf("expecting an error parsing %q", v)
+		}
+	}
+}
+
+func TestGlobalFlagsHelp(t *testing.T) {
+	help := globalFlagsHelp()
+	for _, v := range []string{"-offline", "-cache-ttl", "-json"} {
+		if !strings.Contains(help, v) {
+			t.Errorf("global flags help doesn't describe %s:\n%s", v, help)
+		}
+	}
+	for _, v := range commands {
+		if v.Name != "flags" && !strings.Contains(v.LongHelp, help) {
+			t.Errorf("help for %s doesn't include the global flags", v.Name)
+		}
+	}
+}
+
+func TestSplitRepository(t *testing.T) {
+	tests := []struct {
+		path       string
+		root       string
+		subpackage string
+	}{
+		{"github.com/user/repo", "github.com/user/repo", ""},
+		{"github.com/user/repository", "github.com/user/repository", ""},
+		{"github.com/user/repo/sub", "github.com/user/repo", "/sub"},
+		{"github.com/user/repository/sub/pkg", "github.com/user/repository", "/sub/pkg"},
+		{"github.com/user/repo.go/sub", "github.com/user/repo.go", "/sub"},
+		{"github.com/user", "", ""},
+		{"bitbucket.org/user/repo/sub", "bitbucket.org/user/repo", "/sub"},
+		{"code.google.com/p/go.tools", "code.google.com/p/go.tools", ""},
+		{"code.google.com/p/go.tools/go/types", "code.google.com/p/go.tools", "/go/types"},
+		{"code.google.com/p/go.toolsx.y", "", ""},
+		{"code.google.com/p/vfs/sub", "code.google.com/p/vfs", "/sub"},
+		{"gopkgs.com/vfs.v1", "gopkgs.com/vfs.v1", ""},
+		{"gopkgs.com/vfs.v1/sub", "gopkgs.com/vfs.v1", "/sub"},
+		{"gopkgs.com/gh/user/repo.r0123456789ab/sub", "gopkgs.com/gh/user/repo.r0123456789ab", "/sub"},
+		{"gopkgs.com/gc/go.tools.v1/go/types", "gopkgs.com/gc/go.tools.v1", "/go/types"},
+		{"example.com/user/repo", "", ""},
+		{"fmt", "", ""},
+	}
+	for _, v := range tests {
+		root, subpackage := splitRepository(v.path)
+		if root != v.root || subpackage != v.subpackage {
+			t.Errorf("splitRepository(%q) = %q, %q, want %q, %q", v.path, root, subpackage, v.root, v.subpackage)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+type outdatedOptions struct {
+	All bool `name:"a" help:"Show all pinned imports, not only the outdated ones"`
+}
+
+// pinnedImports returns the pinned gopkgs.com imports used by the
+// given packages and their tests, keyed by repository and pin (e.g.
+// gopkgs.com/vfs.v1), ignoring subpackages.
+func pinnedImports(pkgs []*build.Package) map[string]*lib.ImportPath {
+	pinned := make(map[string]*lib.ImportPath)
+	for _, pkg := range pkgs {
+		var imports []string
+		imports = append(imports, pkg.Imports...)
+		imports = append(imports, pkg.TestImports...)
+		imports = append(imports, pkg.XTestImports...)
+		for _, v := range imports {
+			if imp, err := lib.ParseImportPath(v); err == nil && imp.Pinned() {
+				pinned[imp.Root()] = imp
+			}
+		}
+	}
+	return pinned
+}
+
+// isOutdated returns true iff there's a newer version or revision
+// than the one the import is pinned on.
+func isOutdated(imp *lib.ImportPath, repo *lib.Repo) bool {
+	if imp.Version > 0 {
+		return repo.Version > imp.Version
+	}
+	if imp.Revision != "" && repo.Revision != "" {
+		return !strings.HasPrefix(repo.Revision, imp.Revision) && !strings.HasPrefix(imp.Revision, repo.Revision)
+	}
+	return false
+}
+
+func outdatedCommand(args []string, opts *outdatedOptions) error {
+	if len(args) == 0 {
+		args = []string{"./..."}
+	}
+	pkgs, err := importPackages(args)
+	if err != nil {
+		return err
+	}
+	pinned := pinnedImports(pkgs)
+	if len(pinned) == 0 {
+		fmt.Fprintln(stdout, "no pinned gopkgs.com imports found")
+		return nil
+	}
+	var keys []string
+	for k := range pinned {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	reqs := make([]*lib.RepoRequest, len(keys))
+	for ii, v := range keys {
+		reqs[ii] = &lib.RepoRequest{Path: pinned[v].Base()}
+	}
+	repos, err := Repos(reqs)
+	if err != nil {
+		return err
+	}
+	outdated := 0
+	failed := 0
+	var rows []string
+	for ii, v := range keys {
+		imp := pinned[v]
+		repo := repos[ii]
+		if repo.Error != "" {
+			rows = append(rows, fmt.Sprintf("%s\t%s\t-\t-\terror: %s", imp.Base(), imp.Pin(), repo.Error))
+			emit(&record{Command: "outdated", ImportPath: v, Status: "error", Error: repo.Error})
+			failed++
+			continue
+		}
+		status := "up to date"
+		if isOutdated(imp, repo) {
+			status = "outdated"
+			outdated++
+		} else if !opts.All {
+			continue
+		}
+		emit(&record{Command: "outdated", ImportPath: v, Repo: repo, Status: status})
+		latestVersion := "-"
+		if repo.Version > 0 {
+			latestVersion = "v" + strconv.Itoa(repo.Version)
+		}
+		latestRevision := "-"
+		if repo.Revision != "" {
+			latestRevision = "r" + repo.Revision
+		}
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%s\t%s\t%s", imp.Base(), imp.Pin(), latestVersion, latestRevision, status))
+	}
+	if len(rows) == 0 {
+		fmt.Fprintf(stdout, "all %d pinned imports are up to date\n", len(keys))
+		return nil
+	}
+	if !jsonOutput() {
+		w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "IMPORT\tPIN\tLATEST VERSION\tLATEST REVISION\tSTATUS")
+		for _, v := range rows {
+			fmt.Fprintln(w, v)
+		}
+		w.Flush()
+	}
+	if failed > 0 {
+		return fmt.Errorf("couldn't check %d pinned imports", failed)
+	}
+	if outdated > 0 {
+		return fmt.Errorf("%d of %d pinned imports are outdated", outdated, len(keys))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+func TestIsOutdated(t *testing.T) {
+	const (
+		latest = "0123456789ab"
+		older  = "ba9876543210"
+	)
+	repo := &lib.Repo{Path: "github.com/rainycape/vfs", GoPkgsPath: "gopkgs.com/vfs", Version: 2, Revision: latest}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		// Version pins
+		{"gopkgs.com/vfs.v1", true},
+		{"gopkgs.com/vfs.v1/sub", true},
+		{"gopkgs.com/vfs.v2", false},
+		{"gopkgs.com/vfs.v3", false},
+		// Revision pins
+		{"gopkgs.com/vfs.r" + older, true},
+		{"gopkgs.com/vfs.r" + latest, false},
+		{"gopkgs.com/vfs.r" + latest + "/sub", false},
+		// Shorter and longer prefixes of the latest revision
+		{"gopkgs.com/vfs.r" + latest[:7], false},
+		{"gopkgs.com/vfs.r" + latest + "cdef0123456789abcdef0123", false},
+		{"gopkgs.com/vfs.r" + older[:7], true},
+	}
+	for _, v := range tests {
+		imp, err := lib.ParseImportPath(v.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isOutdated(imp, repo); got != v.want {
+			t.Errorf("isOutdated(%s) = %v, want %v", v.path, got, v.want)
+		}
+	}
+	// Repositories without versions, only pinned on revisions
+	imp, err := lib.ParseImportPath("gopkgs.com/vfs.r" + older)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isOutdated(imp, &lib.Repo{GoPkgsPath: "gopkgs.com/vfs"}) {
+		t.Error("expecting imports to be current when the latest revision is unknown")
+	}
+}
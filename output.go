@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+// record is a machine readable description of the work done by a command.
+// When the global -json flag is used, commands write one record per
+// package or repository they process to stdout, one JSON object per line.
+// Commands which fail before processing any of them write a single
+// record with just Command and Error.
+type record struct {
+	Command string `json:"command"`
+	// Package is the import path of the package being processed
+	Package string `json:"package,omitempty"`
+	// Repo is the repository resolved by gopkgs.com
+	Repo *lib.Repo `json:"repo,omitempty"`
+	// Repos are the repositories resolved by gopkgs.com, for
+	// commands which process several per package.
+	Repos []*lib.Repo `json:"repos,omitempty"`
+	// ImportPath is the chosen import path
+	ImportPath string `json:"import_path,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Status     string `json:"status,omitempty"`
+	// Files are the files changed (or which would be
+	// changed, in dry run mode) by the command.
+	Files    []*fileChange    `json:"files,omitempty"`
+	Skipped  []*skippedImport `json:"skipped,omitempty"`
+	Variants []*repoVariant   `json:"variants,omitempty"`
+	// Info contains the values computed from Repo,
+	// only set by the info command.
+	Info *repoInfo `json:"info,omitempty"`
+	// Versions is only set by the versions command
+	Versions *lib.RepoVersions `json:"versions,omitempty"`
+	// Checkout is only set by the list command
+	Checkout *checkoutInfo `json:"checkout,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Skip records that the given import was not modified
+// due to the given reason.
+func (r *record) Skip(imp string, reason string) {
+	r.Skipped = append(r.Skipped, &skippedImport{Import: imp, Reason: reason})
+}
+
+// SetError sets the record error, if err is non-nil.
+func (r *record) SetError(err error) {
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// fileChange represents the changes made to a file.
+type fileChange struct {
+	File string `json:"file"`
+	// Imports maps the original import paths to the new ones
+	Imports map[string]string `json:"imports"`
+	// Diff is the unified diff of the changes, only set when
+	// using -d.
+	Diff  string `json:"diff,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type skippedImport struct {
+	Import string `json:"import"`
+	Reason string `json:"reason"`
+}
+
+var (
+	// stdout is where commands write their human readable output,
+	// including the output of go get and any other tools.
+	stdout      io.Writer = os.Stdout
+	jsonEncoder *json.Encoder
+	// jsonMu serializes the records written by emit
+	jsonMu sync.Mutex
+	// emitted is the number of records written by emit
+	emitted int
+)
+
+// enableJSONOutput makes emit write records to stdout, while
+// the human readable output is sent to stderr.
+func enableJSONOutput() {
+	jsonEncoder = json.NewEncoder(os.Stdout)
+	stdout = os.Stderr
+}
+
+// jsonOutput returns true iff the -json flag was used.
+func jsonOutput() bool {
+	return jsonEncoder != nil
+}
+
+// emit writes the given record to stdout when using
+// -json. Otherwise, it does nothing.
+func emit(rec *record) {
+	if jsonEncoder != nil {
+		jsonMu.Lock()
+		jsonEncoder.Encode(rec)
+		emitted++
+		jsonMu.Unlock()
+	}
+}
+
+// emitErrors wraps the given command function, which must return an
+// error, so failures which happen before the command writes any record
+// (e.g. when gopkgs.com can't be reached) are still reported as a
+// record with the error when using -json.
+func emitErrors(name string, fn interface{}) interface{} {
+	val := reflect.ValueOf(fn)
+	return reflect.MakeFunc(val.Type(), func(args []reflect.Value) []reflect.Value {
+		out := val.Call(args)
+		if err, ok := out[len(out)-1].Interface().(error); ok && err != nil {
+			jsonMu.Lock()
+			empty := emitted == 0
+			jsonMu.Unlock()
+			if empty {
+				emit(&record{Command: name, Error: err.Error()})
+			}
+		}
+		return out
+	}).Interface()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"gopkgs.com/command.v1"
+)
+
+func TestEnableJSONOutput(t *testing.T) {
+	defer func() {
+		stdout = os.Stdout
+		jsonEncoder = nil
+	}()
+	enableJSONOutput()
+	if !jsonOutput() {
+		t.Error("expecting JSON output to be enabled")
+	}
+	if stdout != os.Stderr {
+		t.Error("expecting human readable output to be sent to stderr")
+	}
+	if f := os.Stdout; f.Fd() != 1 {
+		t.Errorf("os.Stdout was replaced with fd %d", f.Fd())
+	}
+	if err := serveCommand(nil, &serveOptions{}); err == nil {
+		t.Error("expecting serve to reject -json")
+	}
+}
+
+func TestEmitErrors(t *testing.T) {
+	var buf bytes.Buffer
+	defer func() {
+		jsonEncoder = nil
+		emitted = 0
+	}()
+	records := func() []*record {
+		var recs []*record
+		dec := json.NewDecoder(&buf)
+		for {
+			rec := new(record)
+			if err := dec.Decode(rec); err != nil {
+				break
+			}
+			recs = append(recs, rec)
+		}
+		return recs
+	}
+	var info *command.Cmd
+	for _, v := range commands {
+		if v.Name == "info" {
+			info = v
+		}
+	}
+	// Failures before any records are written
+	jsonEncoder, emitted = json.NewEncoder(&buf), 0
+	if err := info.Func.(func([]string) error)(nil); err == nil {
+		t.Fatal("expecting an error from info without arguments")
+	}
+	if recs := records(); len(recs) != 1 || recs[0].Command != "info" || recs[0].Error != "missing package import path" {
+		t.Errorf("expecting a record with the error, got %+v", recs)
+	}
+	// Failures after writing per item records
+	fn := emitErrors("get", func(args []string) error {
+		emit(&record{Command: "get", ImportPath: args[0], Error: "not found"})
+		return errors.New("1 packages failed to download")
+	}).(func([]string) error)
+	jsonEncoder, emitted = json.NewEncoder(&buf), 0
+	if err := fn([]string{"gopkgs.com/vfs.v1"}); err == nil {
+		t.Fatal("expecting an error")
+	}
+	if recs := records(); len(recs) != 1 || recs[0].ImportPath != "gopkgs.com/vfs.v1" {
+		t.Errorf("expecting only the per package record, got %+v", recs)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// isPattern returns true iff p contains a "..." wildcard.
+func isPattern(p string) bool {
+	return strings.Contains(p, "...")
+}
+
+// isLocalPath returns true iff p should be interpreted as a
+// filesystem path rather than as an import path.
+func isLocalPath(p string) bool {
+	return p == "." || p == ".." || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") || filepath.IsAbs(p)
+}
+
+// matchPattern returns a function which matches import paths
+// against the given pattern, using the same rules as the go tool.
+// Note that "foo/..." also matches "foo".
+func matchPattern(pattern string) func(string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	if strings.HasSuffix(re, `/.*`) {
+		re = re[:len(re)-len(`/.*`)] + `(/.*)?`
+	}
+	reg := regexp.MustCompile(`^` + re + `$`)
+	return reg.MatchString
+}
+
+// skipDir returns true iff the directory with the given name
+// should never be considered when expanding a pattern.
+func skipDir(name string) bool {
+	return name == "testdata" || name == "vendor" || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")
+}
+
+// walkPackages walks the directory tree rooted at root, calling
+// fn for every directory which might contain a package.
+func walkPackages(root string, fn func(dir string)) {
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if p != root && skipDir(info.Name()) {
+			return filepath.SkipDir
+		}
+		fn(p)
+		return nil
+	})
+}
+
+// importDir imports the package at dir, returning nil if the
+// directory has no buildable go files.
+func importDir(dir string) (*build.Package, error) {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		if _, ok := err.(*build.NoGoError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// expandLocalPattern expands a pattern like ./... or ../foo/...
+func expandLocalPattern(pattern string) ([]*build.Package, error) {
+	dir := pattern[:strings.Index(pattern, "...")]
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		// Pattern like ./foo... - walk the parent directory
+		dir = path.Dir(dir)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	match := matchPattern(filepath.ToSlash(pattern))
+	var pkgs []*build.Package
+	walkPackages(abs, func(p string) {
+		rel, err := filepath.Rel(abs, p)
+		if err != nil {
+			return
+		}
+		name := filepath.ToSlash(filepath.Join(dir, rel))
+		if !isLocalPath(name) {
+			name = "./" + name
+		}
+		if !match(name) {
+			return
+		}
+		pkg, err := importDir(p)
+		if err != nil {
+			log.Printf("error importing %s: %s", p, err)
+			return
+		}
+		if pkg != nil {
+			pkgs = append(pkgs, pkg)
+		}
+	})
+	return pkgs, nil
+}
+
+// expandImportPattern expands a pattern like github.com/user/...
+// using all the GOPATH entries.
+func expandImportPattern(pattern string) ([]*build.Package, error) {
+	match := matchPattern(pattern)
+	prefix := pattern[:strings.Index(pattern, "...")]
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		prefix = prefix[:i]
+	} else {
+		prefix = ""
+	}
+	seen := make(map[string]bool)
+	var pkgs []*build.Package
+	for _, src := range build.Default.SrcDirs() {
+		if src == filepath.Join(build.Default.GOROOT, "src") {
+			// Don't match standard library packages
+			continue
+		}
+		walkPackages(filepath.Join(src, filepath.FromSlash(prefix)), func(p string) {
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return
+			}
+			name := filepath.ToSlash(rel)
+			if seen[name] || !match(name) {
+				return
+			}
+			seen[name] = true
+			pkg, err := importDir(p)
+			if err != nil {
+				log.Printf("error importing %s: %s", name, err)
+				return
+			}
+			if pkg != nil {
+				pkgs = append(pkgs, pkg)
+			}
+		})
+	}
+	return pkgs, nil
+}
+
+// importPackage imports a single package, given either as a directory
+// or as an import path.
+func importPackage(p string) (*build.Package, error) {
+	if abs, err := filepath.Abs(p); err == nil {
+		if pkg, err := build.ImportDir(abs, 0); err == nil {
+			return pkg, nil
+		}
+	}
+	return build.Import(p, "", 0)
+}
+
+// importPackages returns the packages matching the given arguments, which
+// might be directories, import paths or patterns containing "..." in
+// either of them. If no arguments are given, the package at the current
+// directory is returned. Packages which can't be imported are logged
+// and skipped.
+func importPackages(args []string) ([]*build.Package, error) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	seen := make(map[string]bool)
+	var pkgs []*build.Package
+	add := func(pkg *build.Package) {
+		if !seen[pkg.Dir] {
+			seen[pkg.Dir] = true
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	for _, v := range args {
+		if !isPattern(v) {
+			pkg, err := importPackage(v)
+			if err != nil {
+				log.Printf("error importing %s: %s", v, err)
+				continue
+			}
+			add(pkg)
+			continue
+		}
+		var matches []*build.Package
+		var err error
+		if isLocalPath(v) {
+			matches, err = expandLocalPattern(v)
+		} else {
+			matches, err = expandImportPattern(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			fmt.Fprintf(os.Stderr, "warning: %q matched no packages\n", v)
+		}
+		for _, pkg := range matches {
+			add(pkg)
+		}
+	}
+	return pkgs, nil
+}
+
+// expandImportPaths expands the patterns in args into import paths. Arguments
+// which are not patterns are returned unchanged, even if they're not
+// available locally.
+func expandImportPaths(args []string) ([]string, error) {
+	var paths []string
+	for _, v := range args {
+		if !isPattern(v) {
+			paths = append(paths, v)
+			continue
+		}
+		pkgs, err := importPackages([]string{v})
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range pkgs {
+			paths = append(paths, pkg.ImportPath)
+		}
+	}
+	return paths, nil
+}
+
+// sortPackages sorts the given packages in dependency order, so every
+// package appears after all the packages it imports. Packages with no
+// dependencies between them keep their relative order.
+func sortPackages(pkgs []*build.Package) []*build.Package {
+	byPath := make(map[string]*build.Package, len(pkgs))
+	for _, v := range pkgs {
+		// Packages outside of GOPATH all have "." as their import
+		// path and can't be imported by the rest, so they're only
+		// identified by their directory.
+		if !build.IsLocalImport(v.ImportPath) {
+			byPath[v.ImportPath] = v
+		}
+	}
+	visited := make(map[string]bool)
+	sorted := make([]*build.Package, 0, len(pkgs))
+	var visit func(pkg *build.Package)
+	visit = func(pkg *build.Package) {
+		if visited[pkg.Dir] {
+			return
+		}
+		visited[pkg.Dir] = true
+		imports := append([]string(nil), pkg.Imports...)
+		imports = append(imports, pkg.TestImports...)
+		imports = append(imports, pkg.XTestImports...)
+		sort.Strings(imports)
+		for _, imp := range imports {
+			if dep := byPath[imp]; dep != nil {
+				visit(dep)
+			}
+		}
+		sorted = append(sorted, pkg)
+	}
+	for _, v := range pkgs {
+		visit(v)
+	}
+	return sorted
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"./...", ".", true},
+		{"./...", "./a", true},
+		{"./...", "./a/b", true},
+		{"./a/...", "./a", true},
+		{"./a/...", "./a/b", true},
+		{"./a/...", "./ab", false},
+		{"./a...", "./ab", true},
+		{"github.com/user/...", "github.com/user", true},
+		{"github.com/user/...", "github.com/user/repo", true},
+		{"github.com/user/...", "github.com/user/repo/sub", true},
+		{"github.com/user/...", "github.com/username/repo", false},
+		{"github.com/user/...", "github.com/other/repo", false},
+		{"github.com/user/.../sub", "github.com/user/repo/sub", true},
+		{"github.com/user/.../sub", "github.com/user/repo/other", false},
+		{"gopkgs.com/vfs.v1/...", "gopkgs.com/vfsxv1", false},
+	}
+	for _, v := range tests {
+		if got := matchPattern(v.pattern)(v.path); got != v.want {
+			t.Errorf("matchPattern(%q)(%q) = %v, want %v", v.pattern, v.path, got, v.want)
+		}
+	}
+}
+
+func TestSkipDir(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"testdata", true},
+		{"vendor", true},
+		{".git", true},
+		{"_obj", true},
+		{"src", false},
+		{"vendors", false},
+		{"my_pkg", false},
+	}
+	for _, v := range tests {
+		if got := skipDir(v.name); got != v.want {
+			t.Errorf("skipDir(%q) = %v, want %v", v.name, got, v.want)
+		}
+	}
+}
+
+func TestExpandLocalPattern(t *testing.T) {
+	gopath := writeGOPATH(t, map[string]string{
+		"example.com/a/a.go":               "package a\n",
+		"example.com/a/b/b.go":             "package b\n",
+		"example.com/a/b/c/c.go":           "package c\n",
+		"example.com/a/nogo/README":        "",
+		"example.com/a/testdata/t/t.go":    "package t\n",
+		"example.com/a/vendor/v/v.go":      "package v\n",
+		"example.com/a/.hidden/h.go":       "package h\n",
+		"example.com/a/_skipped/s.go":      "package s\n",
+		"example.com/a/b/testdata/bt/b.go": "package bt\n",
+	})
+	t.Chdir(filepath.Join(gopath, "src", "example.com", "a"))
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"./...", []string{"example.com/a", "example.com/a/b", "example.com/a/b/c"}},
+		{"./b/...", []string{"example.com/a/b", "example.com/a/b/c"}},
+		{"./b/c/...", []string{"example.com/a/b/c"}},
+		{"./nogo/...", nil},
+	}
+	for _, v := range tests {
+		pkgs, err := expandLocalPattern(v.pattern)
+		if err != nil {
+			t.Errorf("expanding %s: %s", v.pattern, err)
+			continue
+		}
+		var got []string
+		for _, pkg := range pkgs {
+			got = append(got, pkg.ImportPath)
+		}
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("expanding %s = %q, want %q", v.pattern, got, v.want)
+		}
+	}
+}
+
+func TestExpandImportPattern(t *testing.T) {
+	writeGOPATH(t, map[string]string{
+		"github.com/user/a/a.go":            "package a\n",
+		"github.com/user/a/b/b.go":          "package b\n",
+		"github.com/user/a/testdata/t/t.go": "package t\n",
+		"github.com/user/a/vendor/v/v.go":   "package v\n",
+		"github.com/user/_c/c.go":           "package c\n",
+		"github.com/username/d/d.go":        "package d\n",
+	})
+	pkgs, err := expandImportPattern("github.com/user/...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pkg := range pkgs {
+		got = append(got, pkg.ImportPath)
+	}
+	want := []string{"github.com/user/a", "github.com/user/a/b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expanding github.com/user/... = %q, want %q", got, want)
+	}
+}
+
+func TestSortPackages(t *testing.T) {
+	a := &build.Package{ImportPath: "example.com/a", Dir: "/src/example.com/a"}
+	b := &build.Package{ImportPath: "example.com/b", Dir: "/src/example.com/b", Imports: []string{"example.com/a", "fmt"}}
+	c := &build.Package{ImportPath: "example.com/c", Dir: "/src/example.com/c", TestImports: []string{"example.com/b"}}
+	d := &build.Package{ImportPath: "example.com/d", Dir: "/src/example.com/d", XTestImports: []string{"example.com/a"}}
+	// Packages outside of GOPATH, with the same import path
+	l1 := &build.Package{ImportPath: ".", Dir: "/tmp/l1", Imports: []string{"example.com/c"}}
+	l2 := &build.Package{ImportPath: ".", Dir: "/tmp/l2", Imports: []string{"example.com/d"}}
+	// Same directory imported twice
+	a2 := *a
+	tests := []struct {
+		pkgs []*build.Package
+		want []*build.Package
+	}{
+		{[]*build.Package{a, &a2, b}, []*build.Package{a, b}},
+		{[]*build.Package{a, b, c}, []*build.Package{a, b, c}},
+		{[]*build.Package{c, b, a}, []*build.Package{a, b, c}},
+		{[]*build.Package{d, c, b, a}, []*build.Package{a, d, b, c}},
+		{[]*build.Package{l1, l2, a, b, c, d}, []*build.Package{a, b, c, l1, d, l2}},
+		{[]*build.Package{l2, l1}, []*build.Package{l2, l1}},
+	}
+	for _, v := range tests {
+		got := sortPackages(v.pkgs)
+		if !reflect.DeepEqual(got, v.want) {
+			t.Errorf("sortPackages(%v) = %v, want %v", dirs(v.pkgs), dirs(got), dirs(v.want))
+		}
+	}
+}
+
+func dirs(pkgs []*build.Package) []string {
+	var dirs []string
+	for _, v := range pkgs {
+		dirs = append(dirs, v.Dir)
+	}
+	return dirs
+}
@@ -1,51 +1,84 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
 
 	"gopkgs.com/cmd/gopkgs/lib"
 )
 
-const (
-	apiHost    = "gopkgs.com"
-	apiVersion = "1"
+var (
+	apiClient = newAPIClient()
+	// apiCache is initialized by openCache, after the global
+	// options have been parsed.
+	apiCache *repoCache
 )
 
 func getApiHost() string {
 	if host := os.Getenv("GOPKGS_API_HOST"); host != "" {
 		return host
 	}
-	return apiHost
+	return lib.DefaultHost
 }
 
-func apiPath(p string) string {
-	return "http://" + getApiHost() + "/api/v" + apiVersion + p
+func getApiScheme() string {
+	if scheme := os.Getenv("GOPKGS_API_SCHEME"); scheme != "" {
+		return scheme
+	}
+	return lib.DefaultScheme
 }
 
-func Repos(reqs []*lib.RepoRequest) ([]*lib.Repo, error) {
-	postData, err := json.Marshal(reqs)
+func newAPIClient() *lib.Client {
+	client := lib.NewClient(getApiHost())
+	client.Scheme = getApiScheme()
+	return client
+}
+
+func openCache() {
+	cache, err := newRepoCache(getApiHost(), globalOpts.CacheTTL)
 	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't open cache, disabling it: %s\n", err)
+		return
+	}
+	apiCache = cache
+}
+
+func Repos(reqs []*lib.RepoRequest) ([]*lib.Repo, error) {
+	repos := make([]*lib.Repo, len(reqs))
+	var pending []*lib.RepoRequest
+	var indexes []int
+	for ii, v := range reqs {
+		if apiCache != nil {
+			if repo := apiCache.Get(v, globalOpts.Offline); repo != nil {
+				repos[ii] = repo
+				continue
+			}
+		}
+		pending = append(pending, v)
+		indexes = append(indexes, ii)
+	}
+	if len(pending) == 0 {
+		return repos, nil
+	}
+	if globalOpts.Offline {
+		err := &offlineError{}
+		for _, v := range pending {
+			err.missing = append(err.missing, v.Path)
+		}
 		return nil, err
 	}
-	resp, err := http.Post(apiPath("/info"), "application/json", bytes.NewReader(postData))
+	resp, err := apiClient.Repos(context.Background(), pending)
 	if err != nil {
 		return nil, err
-
-	}
-	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%d status code from %s: %s", resp.StatusCode, getApiHost(), string(data))
 	}
-	var repos []*lib.Repo
-	if err := json.Unmarshal(data, &repos); err != nil {
-		return nil, fmt.Errorf("error decoding JSON: %s\nResponse:\n%s\n", err, string(data))
+	for ii, v := range resp {
+		repos[indexes[ii]] = v
+		if apiCache != nil && v.Error == "" {
+			// Caching is best effort, ignore any errors
+			apiCache.Put(pending[ii], v)
+		}
 	}
 	return repos, nil
 }
@@ -60,3 +93,16 @@ func Repo(req *lib.RepoRequest) (*lib.Repo, error) {
 	}
 	return repos[0], nil
 }
+
+// Versions returns the version history for the given repositories.
+// Responses are not cached, so it can't be used in offline mode.
+func Versions(reqs []*lib.VersionsRequest) ([]*lib.RepoVersions, error) {
+	if globalOpts.Offline {
+		err := &offlineError{}
+		for _, v := range reqs {
+			err.missing = append(err.missing, v.Path)
+		}
+		return nil, err
+	}
+	return apiClient.Versions(context.Background(), reqs)
+}
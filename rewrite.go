@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"go/ast"
@@ -13,8 +14,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -63,7 +64,10 @@ type rewriteOptions struct {
 	PreferRevisions bool     `name:"r" help:"Prefer revisions to versions"`
 	Library         autoBool `name:"lib" help:"[auto|true|false]: Library mode - refuse to pin packages on revisions, only on versions"`
 	DryRun          bool     `name:"n" help:"Dry run - only show the changes that would be made"`
+	Diff            bool     `name:"d" help:"Print a unified diff of the changes rather than rewriting the files"`
 	Verbose         bool     `name:"v" help:"Verbose output"`
+	NoTests         bool     `name:"notests" help:"Don't rewrite imports in test files"`
+	Local           bool     `name:"local" help:"Pin packages on the revision currently checked out in GOPATH"`
 }
 
 func (opts *rewriteOptions) LibraryMode(pkg *build.Package) bool {
@@ -79,7 +83,7 @@ type rewriteState struct {
 }
 
 func (r *rewriteState) key(req *lib.RepoRequest) string {
-	return req.Path + "|" + req.Revision
+	return repoKey(req)
 }
 
 func (r *rewriteState) Repos(reqs []*lib.RepoRequest) ([]*lib.Repo, error) {
@@ -115,24 +119,39 @@ func (r *rewriteState) RequestRepos(names []string) ([]*lib.Repo, error) {
 			Path: v,
 		}
 	}
-	return Repos(reqs)
+	return r.Repos(reqs)
 }
 
-func (r *rewriteState) DownloadImport(p string, opts *rewriteOptions) error {
+// RequestLocalRepos works like RequestRepos, but it also sends the revision
+// currently checked out in GOPATH for each repository, so the returned
+// import paths are pinned to the code which is being used locally. If the
+// local revision can't be determined, the latest one is requested.
+func (r *rewriteState) RequestLocalRepos(names []string) ([]*lib.Repo, error) {
+	reqs := make([]*lib.RepoRequest, len(names))
+	for ii, v := range names {
+		rev, err := localRevision(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't determine local revision of %s, using latest: %s\n", v, err)
+		}
+		reqs[ii] = &lib.RepoRequest{
+			Path:     v,
+			Revision: rev,
+		}
+	}
+	return r.Repos(reqs)
+}
+
+func (r *rewriteState) DownloadImport(p string, verbose bool) error {
 	if err, ok := r.downloadErrors[p]; ok {
 		return err
 	}
 	var err error
 	if _, ierr := build.Import(p, "", 0); ierr != nil {
-		args := []string{"get"}
-		if opts.Verbose {
-			args = append(args, "-v")
+		var rev string
+		if imp, perr := lib.ParseImportPath(p); perr == nil {
+			rev = imp.Revision
 		}
-		args = append(args, p)
-		cmd := exec.Command("go", args...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err = cmd.Run()
+		err = download(stdout, p, rev, &fetchOptions{Verbose: verbose})
 	}
 	if r.downloadErrors == nil {
 		r.downloadErrors = make(map[string]error)
@@ -163,75 +182,186 @@ func parseFiles(fset *token.FileSet, abspath string, names []string, mode parser
 }
 
 func rewritePackage(pkg *build.Package, st *rewriteState, opts *rewriteOptions) {
-	if err := doRewritePackage(pkg, st, opts); err != nil {
+	rec := &record{Command: "rewrite", Package: pkg.ImportPath}
+	if err := doRewritePackage(pkg, st, opts, rec); err != nil {
 		log.Printf("error rewriting package %s: %s", pkgName(pkg), err)
+		rec.SetError(err)
 	}
+	emit(rec)
 }
 
-func rewriteImports(fset *token.FileSet, pkg *build.Package, files map[string]*ast.File, rewrites map[string]string, st *rewriteState, opts *rewriteOptions) error {
-	for k, v := range files {
+// rewriteMode controls how rewriteImports applies its changes.
+type rewriteMode struct {
+	// DryRun only prints the imports which would be rewritten
+	DryRun bool
+	// Diff prints a unified diff of each file rather than
+	// rewriting it. It implies DryRun.
+	Diff    bool
+	Verbose bool
+}
+
+// rewriteImports rewrites the imports in the given files using the mapImport
+// function, which must return the new import path for the given one or an
+// empty string when the import should be left untouched. New imports are
+// downloaded if required. The changed files and the imports which couldn't
+// be rewritten are recorded in rec.
+func rewriteImports(fset *token.FileSet, files map[string]*ast.File, mapImport func(string) string, st *rewriteState, mode rewriteMode, rec *record) error {
+	dryRun := mode.DryRun || mode.Diff
+	var names []string
+	for k := range files {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := files[k]
 		rewritten := make(map[string]string)
 		imports := astutil.Imports(fset, v)
 		for _, group := range imports {
 			for _, imp := range group {
 				if unquoted, err := strconv.Unquote(imp.Path.Value); err == nil {
-					for rk, rv := range rewrites {
-						if !strings.HasPrefix(unquoted, rk) {
+					newImport := mapImport(unquoted)
+					if newImport == "" || newImport == unquoted {
+						continue
+					}
+					if !dryRun {
+						if err := st.DownloadImport(newImport, mode.Verbose); err != nil {
+							fmt.Fprintf(os.Stderr, "couldn't download %s, using original\n", newImport)
+							rec.Skip(unquoted, fmt.Sprintf("couldn't download %s: %s", newImport, err))
 							continue
 						}
-						newImport := strings.Replace(unquoted, rk, rv, 1)
-						if !opts.DryRun {
-							if err := st.DownloadImport(newImport, opts); err != nil {
-								fmt.Fprintf(os.Stderr, "couldn't download %s, using original", newImport)
-								continue
-							}
-						}
-						rewritten[unquoted] = newImport
 					}
+					rewritten[unquoted] = newImport
 				}
 			}
 		}
 		if len(rewritten) == 0 {
 			continue
 		}
-		if opts.DryRun || opts.Verbose {
-			if opts.DryRun {
-				fmt.Printf("would rewrite %d imports in %s:\n", len(rewritten), k)
+		change := &fileChange{File: k, Imports: rewritten}
+		rec.Files = append(rec.Files, change)
+		if mode.Diff {
+			data, err := fileDiff(fset, v, k, rewritten)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error generating diff for %s: %s\n", k, err)
+				continue
+			}
+			if jsonOutput() {
+				change.Diff = string(data)
 			} else {
-				fmt.Printf("rewrite %d imports in %s:\n", len(rewritten), k)
+				stdout.Write(data)
+			}
+			continue
+		}
+		if dryRun || mode.Verbose {
+			if dryRun {
+				fmt.Fprintf(stdout, "would rewrite %d imports in %s:\n", len(rewritten), k)
+			} else {
+				fmt.Fprintf(stdout, "rewrite %d imports in %s:\n", len(rewritten), k)
 			}
 			for ik, iv := range rewritten {
-				fmt.Printf("\t%s => %s\n", ik, iv)
+				fmt.Fprintf(stdout, "\t%s => %s\n", ik, iv)
 			}
-			if opts.DryRun {
+			if dryRun {
 				continue
 			}
 		}
 		for ik, iv := range rewritten {
 			astutil.RewriteImport(fset, v, ik, iv)
 		}
-		// Same as go fmt
-		cfg := &printer.Config{
-			Tabwidth: 8,
-			Mode:     printer.UseSpaces | printer.TabIndent,
-		}
-		var buf bytes.Buffer
-		var data []byte
-		var st os.FileInfo
-		var err error
-		if err = cfg.Fprint(&buf, fset, v); err == nil {
-			if data, err = format.Source(buf.Bytes()); err == nil {
-				if st, err = os.Stat(k); err == nil {
-					err = ioutil.WriteFile(k, data, st.Mode())
-				}
-			}
+		if err := writeFile(fset, v, k); err != nil {
+			fmt.Fprintf(os.Stderr, "error rewriting file %s: %s\n", k, err)
+			change.Error = err.Error()
 		}
+	}
+	return nil
+}
+
+// fileDiff rewrites the given imports in file and returns a unified
+// diff between filename and the rewritten source.
+func fileDiff(fset *token.FileSet, file *ast.File, filename string, rewritten map[string]string) ([]byte, error) {
+	before, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	for ik, iv := range rewritten {
+		astutil.RewriteImport(fset, file, ik, iv)
+	}
+	after, err := formatFile(fset, file)
+	if err != nil {
+		return nil, err
+	}
+	return diff(filename, before, after)
+}
+
+// formatFile returns the source for the given file, formatted
+// in the same way go fmt does.
+func formatFile(fset *token.FileSet, file *ast.File) ([]byte, error) {
+	// Same as go fmt
+	cfg := &printer.Config{
+		Tabwidth: 8,
+		Mode:     printer.UseSpaces | printer.TabIndent,
+	}
+	var buf bytes.Buffer
+	if err := cfg.Fprint(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// writeFile formats the given file and writes it to filename,
+// preserving its permissions.
+func writeFile(fset *token.FileSet, file *ast.File, filename string) error {
+	data, err := formatFile(fset, file)
+	if err != nil {
+		return err
+	}
+	st, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, st.Mode())
+}
+
+// confirm asks the user the given yes/no question in the terminal,
+// returning true iff the answer is yes. Pressing ctrl+c exits the
+// program.
+func confirm(question string) bool {
+	for {
+		fmt.Fprintf(stdout, "%s (y/N)", question)
+		oldState, err := terminal.MakeRaw(0)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error rewriting file %s: %s\n", k, err)
+			panic(err)
+		}
+		var buf [1]byte
+		os.Stdin.Read(buf[:])
+		terminal.Restore(0, oldState)
+		fmt.Fprint(stdout, "\n")
+		switch buf[0] {
+		case 'y', 'Y':
+			return true
+		case 'n', 'N', '\r': // /r is enter
+			return false
+		case '\x03', '\x01':
+			// ctrl+c, ctrl+z
+			os.Exit(0)
 		}
+	}
+}
 
+// packageFiles returns the names of the files in the package which should
+// be rewritten. Test files are included unless noTests is true.
+func packageFiles(pkg *build.Package, noTests bool) []string {
+	var names []string
+	names = append(names, pkg.GoFiles...)
+	names = append(names, pkg.CgoFiles...)
+	if !noTests {
+		// Tests must be rewritten too, otherwise they might end up
+		// using a different copy of the package than the one
+		// imported by the package they're testing.
+		names = append(names, pkg.TestGoFiles...)
+		names = append(names, pkg.XTestGoFiles...)
 	}
-	return nil
+	return names
 }
 
 func pkgFromExpr(expr ast.Expr) string {
@@ -246,10 +376,9 @@ func pkgFromExpr(expr ast.Expr) string {
 	return ""
 }
 
-func shouldKeepOriginalImport(fset *token.FileSet, p string, spec *ast.ImportSpec, file *ast.File, opts *rewriteOptions) bool {
-	if strings.HasPrefix(p, lib.GoPkgsPrefix) {
-		return true
-	}
+// hasTypeAssertions returns true iff the given file contains type assertions
+// or type switches on types from the package imported by spec.
+func hasTypeAssertions(fset *token.FileSet, p string, spec *ast.ImportSpec, file *ast.File, verbose bool) bool {
 	// If the file contains any type assertions on any type
 	// from the package, don't suggest to rewrite it, since it
 	// might be asserting on an interface which came from another
@@ -265,7 +394,7 @@ func shouldKeepOriginalImport(fset *token.FileSet, p string, spec *ast.ImportSpe
 		pkg, err := build.Import(p, "", 0)
 		if err != nil {
 			// Can't find original package, keep it
-			fmt.Fprintf(os.Stderr, "can't find import %s: %s", p, err)
+			fmt.Fprintf(os.Stderr, "can't find import %s: %s\n", p, err)
 			return true
 		}
 		name = pkg.Name
@@ -276,18 +405,18 @@ func shouldKeepOriginalImport(fset *token.FileSet, p string, spec *ast.ImportSpe
 	keep := false
 	ast.Inspect(file, func(n ast.Node) bool {
 		if ta, ok := n.(*ast.TypeAssertExpr); ok && name == pkgFromExpr(ta.Type) {
-			if opts.Verbose {
+			if verbose {
 				pos := fset.Position(n.Pos())
-				fmt.Printf("keeping import %s due to type assertion in %s:%d\n", p, pos.Filename, pos.Line)
+				fmt.Fprintf(stdout, "keeping import %s due to type assertion in %s:%d\n", p, pos.Filename, pos.Line)
 			}
 			keep = true
 		}
 		if cc, ok := n.(*ast.CaseClause); ok {
 			for _, expr := range cc.List {
 				if name == pkgFromExpr(expr) {
-					if opts.Verbose {
+					if verbose {
 						pos := fset.Position(n.Pos())
-						fmt.Printf("keeping import %s due to case in type switch in %s:%d\n", p, pos.Filename, pos.Line)
+						fmt.Fprintf(stdout, "keeping import %s due to case in type switch in %s:%d\n", p, pos.Filename, pos.Line)
 					}
 					keep = true
 				}
@@ -299,45 +428,108 @@ func shouldKeepOriginalImport(fset *token.FileSet, p string, spec *ast.ImportSpe
 	return keep
 }
 
-func doRewritePackage(pkg *build.Package, st *rewriteState, opts *rewriteOptions) error {
-	libraryMode := opts.LibraryMode(pkg)
-	abs, err := filepath.Abs(pkg.Dir)
-	if err != nil {
-		return err
+// keptImports returns the 3rd party imports in the given files which must
+// not be changed, either because changeable returns false for them (e.g.
+// imports already pointing to gopkgs.com in rewrite) or because changing
+// them would break the package. The package is type checked in order to
+// find any places where values with types from an import meet other
+// packages which still import its current path. If the package can't be
+// type checked, this function falls back to looking for type assertions
+// and type switches involving the imported package. The reasons for
+// keeping each import are recorded in rec.
+func keptImports(fset *token.FileSet, pkg *build.Package, files map[string]*ast.File, changeable func(string) bool, verbose bool, rec *record) (map[string]bool, error) {
+	disabled := make(map[string]bool)
+	candidates := make(map[string]bool)
+	for _, v := range files {
+		imports := astutil.Imports(fset, v)
+		for _, group := range imports {
+			for _, imp := range group {
+				if unquoted, err := strconv.Unquote(imp.Path.Value); err == nil {
+					if root, _ := splitRepository(unquoted); root == "" {
+						continue
+					}
+					if changeable(unquoted) {
+						candidates[unquoted] = true
+					} else {
+						disabled[unquoted] = true
+					}
+				}
+			}
+		}
 	}
-	fset := token.NewFileSet()
-	var names []string
-	names = append(names, pkg.GoFiles...)
-	names = append(names, pkg.CgoFiles...)
-	files, err := parseFiles(fset, abs, names, parser.ParseComments)
-	if err != nil {
-		return err
+	if len(candidates) == 0 {
+		return disabled, nil
+	}
+	conflicts, err := typeConflicts(fset, pkg, files, candidates)
+	if err == nil {
+		var paths []string
+		for k := range conflicts {
+			paths = append(paths, k)
+		}
+		sort.Strings(paths)
+		for _, k := range paths {
+			disabled[k] = true
+			for _, c := range conflicts[k] {
+				fmt.Fprintf(os.Stderr, "%s:%d: keeping import %s: %s\n", c.Pos.Filename, c.Pos.Line, k, c.Reason)
+				rec.Skip(k, c.String())
+			}
+		}
+		return disabled, nil
+	}
+	if verbose {
+		fmt.Fprintf(stdout, "can't type check package %s, looking only for type assertions: %s\n", pkgName(pkg), err)
 	}
-	// First check if we should keep any original imports in the package due to
-	// the use it makes of the imported pkg (type assertions, etc...).
-	disabled := make(map[string]bool)
 	for _, v := range files {
 		imports := astutil.Imports(fset, v)
 		for _, group := range imports {
 			for _, imp := range group {
 				if unquoted, err := strconv.Unquote(imp.Path.Value); err == nil {
-					m := repositoryRe.FindStringSubmatch(unquoted)
-					if len(m) > 0 && shouldKeepOriginalImport(fset, unquoted, imp, v, opts) {
+					if candidates[unquoted] && !disabled[unquoted] && hasTypeAssertions(fset, unquoted, imp, v, verbose) {
 						disabled[unquoted] = true
+						rec.Skip(unquoted, "used in a type assertion or type switch")
 					}
 				}
 			}
 		}
 	}
+	return disabled, nil
+}
+
+// errLocalLibrary is returned when using -local in library mode,
+// since the local revisions can't be pinned on.
+var errLocalLibrary = errors.New("-local pins packages on revisions, which is not allowed in library mode (use -lib=false to override it)")
+
+func doRewritePackage(pkg *build.Package, st *rewriteState, opts *rewriteOptions, rec *record) error {
+	libraryMode := opts.LibraryMode(pkg)
+	if libraryMode && opts.Local {
+		return errLocalLibrary
+	}
+	abs, err := filepath.Abs(pkg.Dir)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	files, err := parseFiles(fset, abs, packageFiles(pkg, opts.NoTests), parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	// First check if we should keep any original imports in the package due to
+	// the use it makes of the imported pkg (type assertions, etc...). Note that
+	// tests are checked too, so an import is either rewritten in the package
+	// and its tests or kept in all of them.
+	notGoPkgs := func(p string) bool { return !isGoPkgsImport(p) }
+	disabled, err := keptImports(fset, pkg, files, notGoPkgs, opts.Verbose, rec)
+	if err != nil {
+		return err
+	}
 	using := make(map[string]bool)
 	for _, v := range files {
 		imports := astutil.Imports(fset, v)
 		for _, group := range imports {
 			for _, imp := range group {
 				if unquoted, err := strconv.Unquote(imp.Path.Value); err == nil {
-					m := repositoryRe.FindStringSubmatch(unquoted)
-					if len(m) > 0 && !disabled[unquoted] {
-						using[m[0]] = true
+					if root, _ := splitRepository(unquoted); root != "" && !disabled[unquoted] {
+						using[root] = true
 					}
 				}
 			}
@@ -352,15 +544,29 @@ func doRewritePackage(pkg *build.Package, st *rewriteState, opts *rewriteOptions
 		repoNames = append(repoNames, k)
 	}
 	if opts.Verbose {
-		fmt.Printf("package %s uses %d 3rd party repositories: %v\n", pkgName(pkg), len(repoNames), repoNames)
+		fmt.Fprintf(stdout, "package %s uses %d 3rd party repositories: %v\n", pkgName(pkg), len(repoNames), repoNames)
+	}
+	var repos []*lib.Repo
+	if opts.Local {
+		repos, err = st.RequestLocalRepos(repoNames)
+	} else {
+		repos, err = st.RequestRepos(repoNames)
 	}
-	repos, err := st.RequestRepos(repoNames)
 	if err != nil {
 		return err
 	}
+	rec.Repos = repos
+	// Map from repository root, as found in the imports,
+	// to its import path at gopkgs.com
 	rewrites := make(map[string]string)
-addRewrites:
-	for _, v := range repos {
+	for ii, v := range repos {
+		if v.Error != "" {
+			if opts.Verbose {
+				fmt.Fprintf(stdout, "ignoring package %s: %s\n", repoNames[ii], v.Error)
+			}
+			rec.Skip(repoNames[ii], v.Error)
+			continue
+		}
 		var importPath string
 		if libraryMode {
 			if v.Version == 0 {
@@ -368,77 +574,64 @@ addRewrites:
 					importPath = v.GoPkgsPath
 				} else {
 					if opts.Verbose {
-						fmt.Printf("ignoring package %s, no versions available\n", v.Path)
+						fmt.Fprintf(stdout, "ignoring package %s, no versions available\n", v.Path)
 					}
+					rec.Skip(v.Path, "no versions available in library mode")
 					continue
 				}
 			} else {
 				importPath = v.VersionImportPath()
 			}
 		} else {
-			if opts.PreferRevisions {
+			if opts.PreferRevisions || opts.Local {
 				importPath = v.RevisionImportPath()
 			} else {
 				importPath = v.VersionImportPath()
 			}
 		}
-		if opts.Interactive {
-		prompt:
-			for {
-				fmt.Printf("rewrite import %s to %s in package %s? (y/N)", v.Path, importPath, pkgName(pkg))
-				oldState, err := terminal.MakeRaw(0)
-				if err != nil {
-					panic(err)
-				}
-				var buf [1]byte
-				os.Stdin.Read(buf[:])
-				terminal.Restore(0, oldState)
-				fmt.Print("\n")
-				switch buf[0] {
-				case 'y', 'Y':
-					break prompt
-				case 'n', 'N', '\r': // /r is enter
-					continue addRewrites
-				case '\x03', '\x01':
-					// ctrl+c, ctrl+z
-					os.Exit(0)
-				}
-			}
-
+		if opts.Interactive && !confirm(fmt.Sprintf("rewrite import %s to %s in package %s?", v.Path, importPath, pkgName(pkg))) {
+			rec.Skip(v.Path, "declined by the user")
+			continue
 		}
-		rewrites[v.Path] = importPath
+		rewrites[repoNames[ii]] = importPath
 	}
 	if len(rewrites) == 0 {
 		return nil
 	}
-	// TODO go get new imports
-	return rewriteImports(fset, pkg, files, rewrites, st, opts)
+	mapImport := importMapper(rewrites, disabled)
+	return rewriteImports(fset, files, mapImport, st, rewriteMode{DryRun: opts.DryRun, Diff: opts.Diff, Verbose: opts.Verbose}, rec)
 }
 
-func rewriteSubcommand(args []string, opts *rewriteOptions) {
-	st := new(rewriteState)
-	if len(args) > 0 {
-		for _, v := range args {
-			if pkg, err := build.ImportDir(v, 0); err == nil {
-				rewritePackage(pkg, st, opts)
-				continue
-			}
-			pkg, err := build.Import(v, "", 0)
-			if err != nil {
-				log.Printf("error importing %s: %s", v, err)
-				continue
-			}
-			rewritePackage(pkg, st, opts)
+// importMapper returns a function for rewriteImports which maps import paths
+// using rewrites, keyed by repository root. Imports in disabled are always
+// kept, even if other packages from the same repository are rewritten.
+func importMapper(rewrites map[string]string, disabled map[string]bool) func(string) string {
+	return func(p string) string {
+		if disabled[p] {
+			return ""
 		}
-	} else {
-		abs, err := filepath.Abs(".")
-		if err != nil {
-			panic(err)
-		}
-		pkg, err := build.ImportDir(abs, 0)
-		if err != nil {
-			log.Fatalf("error importing %s: %s", abs, err)
+		root, subpackage := splitRepository(p)
+		if v := rewrites[root]; v != "" {
+			return v + subpackage
 		}
+		return ""
+	}
+}
+
+func rewriteSubcommand(args []string, opts *rewriteOptions) error {
+	if opts.Local && !opts.Library.Auto() && opts.Library.Bool() {
+		return errLocalLibrary
+	}
+	pkgs, err := importPackages(args)
+	if err != nil {
+		return err
+	}
+	// Rewrite dependencies first, so the repositories they use
+	// are already resolved and downloaded when rewriting the
+	// packages which import them.
+	st := new(rewriteState)
+	for _, pkg := range sortPackages(pkgs) {
 		rewritePackage(pkg, st, opts)
 	}
+	return nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestImportMapperKeepsDisabledSubpackage(t *testing.T) {
+	const src = `package a
+
+import (
+	"github.com/a/b"
+	"github.com/a/b/kept"
+	"github.com/a/b/rewritten"
+	"github.com/c/d"
+)
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "a.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrites := map[string]string{"github.com/a/b": "gopkgs.com/gh/a/b.v1"}
+	disabled := map[string]bool{"github.com/a/b/kept": true}
+	rec := &record{}
+	mode := rewriteMode{DryRun: true}
+	if err := rewriteImports(fset, map[string]*ast.File{"a.go": f}, importMapper(rewrites, disabled), new(rewriteState), mode, rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Files) != 1 {
+		t.Fatalf("expecting 1 changed file, got %d", len(rec.Files))
+	}
+	want := map[string]string{
+		"github.com/a/b":           "gopkgs.com/gh/a/b.v1",
+		"github.com/a/b/rewritten": "gopkgs.com/gh/a/b.v1/rewritten",
+	}
+	got := rec.Files[0].Imports
+	if len(got) != len(want) {
+		t.Errorf("expecting %d rewritten imports, got %v", len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("expecting %s to be rewritten to %s, got %q", k, v, got[k])
+		}
+	}
+	if v, ok := got["github.com/a/b/kept"]; ok {
+		t.Errorf("github.com/a/b/kept should be kept, but it was rewritten to %s", v)
+	}
+}
+
+func TestRewriteLocalLibraryMode(t *testing.T) {
+	opts := &rewriteOptions{Library: "auto", Local: true}
+	pkg := &build.Package{Name: "vfs", Dir: t.TempDir()}
+	if err := doRewritePackage(pkg, new(rewriteState), opts, &record{}); err != errLocalLibrary {
+		t.Errorf("expecting errLocalLibrary for a library package, got %v", err)
+	}
+	opts.Library = "true"
+	if err := rewriteSubcommand(nil, opts); err != errLocalLibrary {
+		t.Errorf("expecting errLocalLibrary with -lib=true, got %v", err)
+	}
+}
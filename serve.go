@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gopkgs.com/cmd/gopkgs/server"
+)
+
+type serveOptions struct {
+	Addr                string `name:"http" help:"Address to listen on"`
+	Dir                 string `name:"repos" help:"Directory containing the git mirrors to serve"`
+	DocumentationPrefix string `name:"doc" help:"Prefix for documentation URLs"`
+}
+
+func serveCommand(args []string, opts *serveOptions) error {
+	if jsonOutput() {
+		return errors.New("serve doesn't support -json")
+	}
+	s := server.New(opts.Dir)
+	if opts.DocumentationPrefix != "" {
+		s.DocumentationPrefix = opts.DocumentationPrefix
+	}
+	// Find the mirrors before serving any requests, so
+	// errors in the directory are reported right away.
+	if _, err := s.Refresh(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "serving repositories in %s on %s\n", opts.Dir, opts.Addr)
+	return http.ListenAndServe(opts.Addr, s)
+}
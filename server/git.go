@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	versionRe = regexp.MustCompile(`^v([0-9]+)(?:\.[0-9]+)*$`)
+	// remoteRe matches git remote URLs, like https://github.com/user/repo.git
+	// or git@github.com:user/repo.git, capturing the host and the path.
+	remoteRe = regexp.MustCompile(`^(?:[a-z+]+://)?(?:[^@/]+@)?([^/:]+)(?::[0-9]+)?[:/](.+?)(?:\.git)?/?$`)
+)
+
+// git runs git with the given arguments in the repository at dir,
+// returning its trimmed output.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"--git-dir", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// resolveCommit returns the full hash of the commit the given revision
+// resolves to in the repository at dir. The revision must have been
+// validated by the caller, but it's never interpreted as an option.
+func resolveCommit(dir string, rev string) (string, error) {
+	return git(dir, "rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}")
+}
+
+// gitDir returns the git directory for the repository at dir, which
+// might be either a bare repository or a checkout. If dir is not a
+// git repository, an empty string is returned.
+func gitDir(dir string) string {
+	if st, err := os.Stat(filepath.Join(dir, ".git")); err == nil && st.IsDir() {
+		return filepath.Join(dir, ".git")
+	}
+	for _, v := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, v)); err != nil {
+			return ""
+		}
+	}
+	return dir
+}
+
+// remotePath returns the import path corresponding to the given
+// git remote URL, or an empty string if the URL doesn't point
+// to a remote host.
+func remotePath(url string) string {
+	if strings.HasPrefix(url, "file://") || filepath.IsAbs(url) {
+		return ""
+	}
+	m := remoteRe.FindStringSubmatch(url)
+	if m == nil || !strings.Contains(m[1], ".") {
+		return ""
+	}
+	return m[1] + "/" + m[2]
+}
+
+// gitVersion represents a vN tag or branch in a repository.
+type gitVersion struct {
+	Number int
+	Ref    string
+	Commit string
+	// parts contains all the numeric components in the ref
+	// name, e.g. v1.2.3 => [1, 2, 3]
+	parts []int
+}
+
+// less returns true iff other should be preferred over v
+// for the same version number.
+func (v *gitVersion) less(other *gitVersion) bool {
+	if v.isBranch() != other.isBranch() {
+		return other.isBranch()
+	}
+	return v.before(other)
+}
+
+func (v *gitVersion) isBranch() bool {
+	return strings.HasPrefix(v.Ref, "refs/heads/")
+}
+
+// before returns true iff v sorts before other, comparing their
+// numeric components (e.g. v1 < v1.2 < v1.10 < v2). Tags sort
+// before branches with the same components.
+func (v *gitVersion) before(other *gitVersion) bool {
+	for ii := 0; ii < len(v.parts) && ii < len(other.parts); ii++ {
+		if v.parts[ii] != other.parts[ii] {
+			return v.parts[ii] < other.parts[ii]
+		}
+	}
+	if len(v.parts) != len(other.parts) {
+		return len(v.parts) < len(other.parts)
+	}
+	return !v.isBranch() && other.isBranch()
+}
+
+// gitVersions returns all the versions available in the repository
+// at dir, sorted by their numeric components (see gitVersion.before).
+// Use majorVersions to find the ones import paths resolve to.
+func gitVersions(dir string) ([]*gitVersion, error) {
+	out, err := git(dir, "for-each-ref", "--format=%(refname) %(objectname) %(*objectname)", "refs/tags", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	var versions []*gitVersion
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ref := fields[0]
+		commit := fields[1]
+		if len(fields) > 2 {
+			// Annotated tag, use the commit it points to
+			commit = fields[2]
+		}
+		name := ref[strings.LastIndex(ref, "/")+1:]
+		if !versionRe.MatchString(name) {
+			continue
+		}
+		var parts []int
+		for _, p := range strings.Split(name[1:], ".") {
+			n, _ := strconv.Atoi(p)
+			parts = append(parts, n)
+		}
+		if parts[0] == 0 {
+			continue
+		}
+		versions = append(versions, &gitVersion{Number: parts[0], Ref: ref, Commit: commit, parts: parts})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].before(versions[j])
+	})
+	return versions, nil
+}
+
+// majorVersions returns, for each version number in versions, the
+// version which import paths like gopkgs.com/vfs.v1 resolve to. If
+// several refs match the same number (e.g. tag v1.0 and branch v1),
+// the branch is preferred and then the highest tag (e.g. v1.10 is
+// preferred over v1.9). The result is sorted by number.
+func majorVersions(versions []*gitVersion) []*gitVersion {
+	var majors []*gitVersion
+	byNumber := make(map[int]int)
+	for _, v := range versions {
+		ii, found := byNumber[v.Number]
+		if !found {
+			byNumber[v.Number] = len(majors)
+			majors = append(majors, v)
+			continue
+		}
+		if majors[ii].less(v) {
+			majors[ii] = v
+		}
+	}
+	sort.Slice(majors, func(i, j int) bool {
+		return majors[i].Number < majors[j].Number
+	})
+	return majors
+}
+
+// gitCommit represents a commit in a repository.
+type gitCommit struct {
+	Hash    string
+	Date    time.Time
+	Subject string
+}
+
+// parseCommits parses the output of git log or git show using
+// the %H %ct %s format, one commit per line.
+func parseCommits(out string) []*gitCommit {
+	var commits []*gitCommit
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		c := &gitCommit{Hash: fields[0], Date: time.Unix(ts, 0).UTC()}
+		if len(fields) > 2 {
+			c.Subject = fields[2]
+		}
+		commits = append(commits, c)
+	}
+	return commits
+}
+
+// gitLog returns up to n commits reachable from rev, most recent first.
+func gitLog(dir string, rev string, n int) ([]*gitCommit, error) {
+	out, err := git(dir, "log", "-n", strconv.Itoa(n), "--format=%H %ct %s", rev)
+	if err != nil {
+		return nil, err
+	}
+	return parseCommits(out), nil
+}
+
+// gitShow returns the given commits, keyed by their hash.
+func gitShow(dir string, hashes ...string) (map[string]*gitCommit, error) {
+	commits := make(map[string]*gitCommit)
+	if len(hashes) == 0 {
+		return commits, nil
+	}
+	args := append([]string{"show", "-s", "--format=%H %ct %s"}, hashes...)
+	out, err := git(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range parseCommits(out) {
+		commits[v.Hash] = v
+	}
+	return commits, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestGitVersions(t *testing.T) {
+	m := newTestMirror(t, "vfs", "")
+	c1 := m.Commit("one", "v1.0")
+	c2 := m.Commit("two", "v1.10", "v2")
+	c3 := m.Commit("three", "v1.9", "v0.1", "v1.x")
+	versions, err := gitVersions(m.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		ref    string
+		commit string
+	}{
+		{"refs/tags/v1.0", c1},
+		{"refs/tags/v1.9", c3},
+		{"refs/tags/v1.10", c2},
+		{"refs/tags/v2", c2},
+	}
+	if len(versions) != len(want) {
+		t.Fatalf("expecting %d versions, got %d", len(want), len(versions))
+	}
+	for ii, v := range want {
+		if versions[ii].Ref != v.ref || versions[ii].Commit != v.commit {
+			t.Errorf("version %d = %s at %s, want %s at %s", ii, versions[ii].Ref, versions[ii].Commit, v.ref, v.commit)
+		}
+	}
+	majors := majorVersions(versions)
+	if len(majors) != 2 || majors[0].Ref != "refs/tags/v1.10" || majors[1].Ref != "refs/tags/v2" {
+		t.Errorf("unexpected major versions %v, %v", majors[0].Ref, majors[1].Ref)
+	}
+	// Branches are preferred over any tag
+	runGit(t, m.Dir, "branch", "v1", c1)
+	if versions, err = gitVersions(m.Dir); err != nil {
+		t.Fatal(err)
+	}
+	if v := versions[0]; v.Ref != "refs/heads/v1" {
+		t.Errorf("expecting branch v1 to sort first, got %s", v.Ref)
+	}
+	if majors := majorVersions(versions); majors[0].Ref != "refs/heads/v1" {
+		t.Errorf("expecting branch v1 to be preferred, got %s", majors[0].Ref)
+	}
+}
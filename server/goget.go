@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+const (
+	uploadPack = "git-upload-pack"
+	masterRef  = "refs/heads/master"
+)
+
+var (
+	goGetTemplate = template.Must(template.New("goget").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+<meta name="go-import" content="{{ .Prefix }} git {{ .RepoURL }}">
+<meta name="go-source" content="{{ .Prefix }} {{ .Home }} {{ .Dir }} {{ .File }}">
+</head>
+<body>
+go get {{ .Prefix }}
+</body>
+</html>
+`))
+)
+
+// pkgRequest represents a request for a versioned package.
+type pkgRequest struct {
+	mirror *mirror
+	// ImportPath is the import path of the repository, including
+	// the version or revision, e.g. gopkgs.com/vfs.v1
+	ImportPath string
+	// Rest is the remaining of the URL path, after the
+	// repository name and the version.
+	Rest string
+	// Commit is the full hash of the commit which the
+	// version or revision resolves to.
+	Commit string
+}
+
+// resolvePackage parses the URL path into a pkgRequest. URL paths for
+// versioned packages are the import paths without the gopkgs.com prefix,
+// like /vfs.v1, /gh/user/repo.r1a2b3c/subpkg or /vfs.v2/info/refs. If
+// the path can't be resolved, an error message and its status code are
+// returned.
+func (s *Server) resolvePackage(p string) (*pkgRequest, int, string) {
+	imp, err := lib.ParseImportPath(lib.GoPkgsPrefix + strings.TrimPrefix(p, "/"))
+	if err != nil {
+		return nil, http.StatusNotFound, "invalid package path"
+	}
+	mirrors, err := s.mirrors()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err.Error()
+	}
+	mr := mirrors.byName[imp.Name()]
+	if mr == nil {
+		return nil, http.StatusNotFound, "unknown package " + imp.Name()
+	}
+	req := &pkgRequest{mirror: mr, ImportPath: imp.Root(), Rest: imp.Subpackage}
+	switch {
+	case imp.Version > 0:
+		versions, err := gitVersions(mr.Dir)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err.Error()
+		}
+		for _, v := range majorVersions(versions) {
+			if v.Number == imp.Version {
+				req.Commit = v.Commit
+			}
+		}
+		if req.Commit == "" {
+			return nil, http.StatusNotFound, fmt.Sprintf("package %s has no version %d", mr.Name, imp.Version)
+		}
+	case imp.Revision != "":
+		commit, err := resolveCommit(mr.Dir, imp.Revision)
+		if err != nil || commit == "" {
+			return nil, http.StatusNotFound, fmt.Sprintf("package %s has no revision %s", mr.Name, imp.Revision)
+		}
+		req.Commit = commit
+	default:
+		if !mr.AllowsUnpinned {
+			return nil, http.StatusNotFound, fmt.Sprintf("package %s must be pinned on a version or revision", mr.Name)
+		}
+	}
+	return req, 0, ""
+}
+
+func (s *Server) serveGoGet(w http.ResponseWriter, r *http.Request, req *pkgRequest) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	// Note that import paths always start with gopkgs.com, but
+	// the repository is served from the host the request was
+	// made to.
+	name := strings.TrimPrefix(req.ImportPath, lib.GoPkgsPrefix)
+	data := map[string]string{
+		"Prefix":  req.ImportPath,
+		"RepoURL": scheme + "://" + r.Host + "/" + name,
+		"Home":    s.DocumentationPrefix + req.ImportPath,
+		"Dir":     "_",
+		"File":    "_",
+	}
+	if orig := req.mirror.Path; strings.HasPrefix(orig, lib.GitHubPrefix) {
+		ref := req.Commit
+		if ref == "" {
+			ref = "master"
+		}
+		home := "https://" + orig
+		data["Home"] = home
+		data["Dir"] = home + "/tree/" + ref + "{/dir}"
+		data["File"] = home + "/blob/" + ref + "{/dir}/{file}#L{line}"
+	}
+	var buf bytes.Buffer
+	if err := goGetTemplate.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// readPktLines reads all the git pkt-lines in r, up to the
+// first flush packet, which is not included.
+func readPktLines(r *bufio.Reader) ([]string, error) {
+	var lines []string
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseUint(string(size[:]), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pkt-line length %q", string(size[:]))
+		}
+		if n == 0 {
+			return lines, nil
+		}
+		if n < 4 {
+			return nil, fmt.Errorf("invalid pkt-line length %d", n)
+		}
+		data := make([]byte, n-4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		lines = append(lines, string(data))
+	}
+}
+
+func writePktLine(w io.Writer, line string) {
+	fmt.Fprintf(w, "%04x%s", len(line)+4, line)
+}
+
+// rewriteRefs rewrites the refs advertised by git upload-pack so only
+// HEAD and the master branch are advertised, both pointing to commit.
+// This way, clients which clone the repository (e.g. go get) always
+// check out the requested version or revision, rather than picking
+// another ref. Fetching commit requires uploadpack.allowReachableSHA1InWant,
+// since it might not be at the tip of any ref.
+func rewriteRefs(lines []string, commit string) []string {
+	var caps []string
+	if len(lines) > 0 {
+		v := strings.TrimSuffix(lines[0], "\n")
+		if p := strings.IndexByte(v, 0); p >= 0 {
+			for _, c := range strings.Fields(v[p+1:]) {
+				if !strings.HasPrefix(c, "symref=") {
+					caps = append(caps, c)
+				}
+			}
+		}
+	}
+	caps = append(caps, "symref=HEAD:"+masterRef)
+	return []string{
+		commit + " HEAD\x00" + strings.Join(caps, " ") + "\n",
+		commit + " " + masterRef + "\n",
+	}
+}
+
+func (s *Server) serveInfoRefs(w http.ResponseWriter, r *http.Request, req *pkgRequest) {
+	if service := r.URL.Query().Get("service"); service != uploadPack {
+		http.Error(w, "only "+uploadPack+" is supported", http.StatusForbidden)
+		return
+	}
+	cmd := exec.Command("git", "upload-pack", "--stateless-rpc", "--advertise-refs", req.mirror.Dir)
+	out, err := cmd.Output()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	lines, err := readPktLines(bufio.NewReader(bytes.NewReader(out)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Commit != "" {
+		lines = rewriteRefs(lines, req.Commit)
+	}
+	var buf bytes.Buffer
+	writePktLine(&buf, "# service="+uploadPack+"\n")
+	buf.WriteString("0000")
+	for _, v := range lines {
+		writePktLine(&buf, v)
+	}
+	buf.WriteString("0000")
+	w.Header().Set("Content-Type", "application/x-"+uploadPack+"-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) serveUploadPack(w http.ResponseWriter, r *http.Request, req *pkgRequest) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	// Revisions might not be at the tip of any ref, allow fetching
+	// any commit reachable from them. This is also set in the mirror
+	// configuration by scanMirrors, but passing it here too keeps
+	// read only mirrors working.
+	cmd := exec.Command("git", "-c", allowReachableKey+"=true", "upload-pack", "--stateless-rpc", req.mirror.Dir)
+	cmd.Stdin = body
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-"+uploadPack+"-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(out.Bytes())
+}
+
+// servePackage serves the go-import metadata and the git smart HTTP
+// protocol (fetch only) for versioned packages.
+func (s *Server) servePackage(w http.ResponseWriter, r *http.Request) {
+	req, code, msg := s.resolvePackage(r.URL.Path)
+	if req == nil {
+		http.Error(w, msg, code)
+		return
+	}
+	switch {
+	case req.Rest == "/info/refs":
+		s.serveInfoRefs(w, r, req)
+	case req.Rest == "/"+uploadPack:
+		s.serveUploadPack(w, r, req)
+	case r.URL.Query().Get("go-get") == "1":
+		s.serveGoGet(w, r, req)
+	default:
+		http.Redirect(w, r, s.DocumentationPrefix+req.ImportPath+req.Rest, http.StatusFound)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteRefs(t *testing.T) {
+	const (
+		old    = "1111111111111111111111111111111111111111"
+		commit = "2222222222222222222222222222222222222222"
+	)
+	lines := []string{
+		old + " HEAD\x00multi_ack thin-pack symref=HEAD:refs/heads/master agent=git/2\n",
+		old + " refs/heads/master\n",
+		old + " refs/heads/v1\n",
+		old + " refs/tags/v2\n",
+	}
+	got := rewriteRefs(lines, commit)
+	want := []string{
+		commit + " HEAD\x00multi_ack thin-pack agent=git/2 symref=HEAD:refs/heads/master\n",
+		commit + " refs/heads/master\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expecting %d refs, got %q", len(want), got)
+	}
+	for ii := range want {
+		if got[ii] != want[ii] {
+			t.Errorf("ref %d = %q, want %q", ii, got[ii], want[ii])
+		}
+	}
+}
+
+func TestScanAllowsReachableCommits(t *testing.T) {
+	m := newTestMirror(t, "vfs", "")
+	m.Commit("one")
+	if _, err := scanMirrors(m.Root); err != nil {
+		t.Fatal(err)
+	}
+	if v := runGit(t, m.Dir, "config", "--bool", "--get", allowReachableKey); v != "true" {
+		t.Errorf("expecting %s to be true after scanning, got %q", allowReachableKey, v)
+	}
+}
+
+func TestServeGoGet(t *testing.T) {
+	m := newTestMirror(t, "vfs", "github.com/rainycape/vfs")
+	first := m.Commit("one", "v1")
+	s := New(m.Root)
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/vfs.v1/sub?go-get=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code %d: %s", resp.StatusCode, data)
+	}
+	body := string(data)
+	for _, v := range []string{
+		`<meta name="go-import" content="gopkgs.com/vfs.v1 git ` + srv.URL + `/vfs.v1">`,
+		"https://github.com/rainycape/vfs/tree/" + first + "{/dir}",
+	} {
+		if !strings.Contains(body, v) {
+			t.Errorf("expecting %q in go get response:\n%s", v, body)
+		}
+	}
+	for _, v := range []string{"/vfs.v2?go-get=1", "/vfs?go-get=1", "/other.v1?go-get=1"} {
+		resp, err := http.Get(srv.URL + v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expecting status %d for %s, got %d", http.StatusNotFound, v, resp.StatusCode)
+		}
+	}
+}
+
+func TestGoGetClone(t *testing.T) {
+	m := newTestMirror(t, "vfs", "github.com/rainycape/vfs")
+	first := m.Commit("one", "v1")
+	second := m.Commit("two")
+	third := m.Commit("three", "v2")
+	m.Commit("four")
+	s := New(m.Root)
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	tests := []struct {
+		name   string
+		commit string
+	}{
+		{"vfs.v1", first},
+		{"vfs.v2", third},
+		// Not at the tip of any ref
+		{"vfs.r" + second[:RevisionLength], second},
+	}
+	tmp := t.TempDir()
+	for _, v := range tests {
+		url := srv.URL + "/" + v.name
+		refs := strings.Split(runGit(t, tmp, "ls-remote", url), "\n")
+		want := []string{v.commit + "\tHEAD", v.commit + "\trefs/heads/master"}
+		if len(refs) != len(want) || refs[0] != want[0] || refs[1] != want[1] {
+			t.Errorf("%s advertises refs %q, want %q", v.name, refs, want)
+		}
+		dir := filepath.Join(tmp, v.name)
+		runGit(t, tmp, "clone", "-q", url, dir)
+		if head := runGit(t, dir, "rev-parse", "HEAD"); head != v.commit {
+			t.Errorf("expecting %s after cloning %s, got %s", v.commit, v.name, head)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+// Package server implements the gopkgs.com API using a directory
+// of git mirrors as its backend.
+//
+// Every git repository (either bare or not) found inside the
+// directory is served at gopkgs.com/<name>, where name is the path
+// of the repository relative to the directory, without any .git
+// suffix. e.g. a mirror at <dir>/vfs.git is served as gopkgs.com/vfs,
+// while <dir>/gh/rainycape/vfs is served as gopkgs.com/gh/rainycape/vfs.
+//
+// The original import path for each repository is taken from the
+// gopkgs.path git configuration key or, if not set, from the URL of
+// its origin remote. Versions are discovered from tags or branches
+// named vN (optionally followed by .N components, e.g. v1.2), while
+// setting gopkgs.allowunpinned to true makes the repository available
+// without a version or revision. The directory is scanned again every
+// RefreshInterval, so mirrors can be added or removed without restarting
+// the server.
+//
+// Besides the API, the server also implements the go get protocol for
+// versioned import paths (e.g. gopkgs.com/vfs.v1), serving the go-import
+// metadata and a read only git repository whose HEAD points to the
+// requested version or revision. Since pinned revisions might not be at
+// the tip of any ref, the server sets uploadpack.allowReachableSHA1InWant
+// to true in every mirror it finds.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+const (
+	// DefaultDocumentationPrefix is the DocumentationPrefix used
+	// by servers which don't specify one.
+	DefaultDocumentationPrefix = "http://godoc.org/"
+
+	// RevisionLength is the number of characters of each commit
+	// hash used in revision import paths.
+	RevisionLength = lib.RevisionLength
+
+	// DefaultRevisions is the number of revisions returned by the
+	// versions endpoint when the request doesn't specify it.
+	DefaultRevisions = 10
+	// MaxRevisions is the maximum number of revisions returned
+	// by the versions endpoint.
+	MaxRevisions = 100
+
+	// DefaultRefreshInterval is the RefreshInterval used by
+	// servers which don't specify one.
+	DefaultRefreshInterval = time.Minute
+
+	allowReachableKey = "uploadpack.allowReachableSHA1InWant"
+
+	infoPath     = "/api/v" + lib.APIVersion + "/info"
+	versionsPath = "/api/v" + lib.APIVersion + "/versions"
+)
+
+// Server implements the gopkgs.com API. Use New to
+// initialize a Server.
+type Server struct {
+	// Dir is the directory containing the git mirrors.
+	Dir string
+	// DocumentationPrefix is returned in lib.Repo.DocumentationPrefix.
+	DocumentationPrefix string
+	// RefreshInterval is the interval between scans of Dir for
+	// new or removed mirrors. The first scan happens when Refresh
+	// is called or, otherwise, when the first request is served. If
+	// zero, Dir is only scanned once.
+	RefreshInterval time.Duration
+
+	mu      sync.Mutex
+	scanned *mirrorSet
+	err     error
+	timer   *time.Timer
+	closed  bool
+}
+
+// New returns a new Server which serves the git mirrors at dir.
+func New(dir string) *Server {
+	return &Server{
+		Dir:                 dir,
+		DocumentationPrefix: DefaultDocumentationPrefix,
+		RefreshInterval:     DefaultRefreshInterval,
+	}
+}
+
+// mirror represents a git repository in the server directory.
+type mirror struct {
+	// Name is the name of the repository at gopkgs.com
+	Name string
+	// Dir is the git directory of the repository
+	Dir string
+	// Path is the original import path, or empty if unknown.
+	Path string
+	// AllowsUnpinned is the value of gopkgs.allowunpinned
+	AllowsUnpinned bool
+}
+
+func (m *mirror) GoPkgsPath() string {
+	return lib.GoPkgsPrefix + m.Name
+}
+
+// mirrorSet contains all the repositories in the server directory.
+type mirrorSet struct {
+	list   []*mirror
+	byName map[string]*mirror
+}
+
+// find returns the repository for the given import path,
+// which might be either the original path or the path at gopkgs.com.
+// If the path includes a subpackage, the repository containing it is
+// returned.
+func (ms *mirrorSet) find(p string) *mirror {
+	if imp, err := lib.ParseImportPath(p); err == nil {
+		if m := ms.byName[imp.Name()]; m != nil {
+			return m
+		}
+	}
+	for _, v := range ms.list {
+		if v.Path != "" && (p == v.Path || strings.HasPrefix(p, v.Path+"/")) {
+			return v
+		}
+	}
+	return nil
+}
+
+// mirrors returns the repositories found by the last scan of the
+// server directory, scanning it if it hasn't been scanned yet.
+func (s *Server) mirrors() (*mirrorSet, error) {
+	s.mu.Lock()
+	scanned, err := s.scanned, s.err
+	s.mu.Unlock()
+	if scanned == nil && err == nil {
+		return s.Refresh()
+	}
+	return scanned, err
+}
+
+// Refresh scans the server directory for mirrors and schedules the next
+// scan after RefreshInterval. Requests are served using the repositories
+// found by the last scan.
+func (s *Server) Refresh() (*mirrorSet, error) {
+	scanned, err := scanMirrors(s.Dir)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		log.Printf("error scanning mirrors in %s: %s", s.Dir, err)
+		if s.scanned != nil {
+			// Keep serving the last known mirrors
+			err = nil
+		}
+	} else {
+		s.scanned = scanned
+	}
+	s.err = err
+	if s.RefreshInterval > 0 && !s.closed {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.timer = time.AfterFunc(s.RefreshInterval, func() { s.Refresh() })
+	}
+	return s.scanned, err
+}
+
+// Close stops refreshing the mirrors.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// scanMirrors returns all the repositories in the given directory.
+func scanMirrors(base string) (*mirrorSet, error) {
+	root, err := filepath.Abs(base)
+	if err != nil {
+		return nil, err
+	}
+	ms := &mirrorSet{byName: make(map[string]*mirror)}
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() || p == root {
+			return err
+		}
+		dir := gitDir(p)
+		if dir == "" {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		m := &mirror{
+			Name: strings.TrimSuffix(filepath.ToSlash(rel), ".git"),
+			Dir:  dir,
+		}
+		if path, _ := git(dir, "config", "--get", "gopkgs.path"); path != "" {
+			m.Path = path
+		} else if url, _ := git(dir, "config", "--get", "remote.origin.url"); url != "" {
+			m.Path = remotePath(url)
+		}
+		if allows, _ := git(dir, "config", "--bool", "--get", "gopkgs.allowunpinned"); allows == "true" {
+			m.AllowsUnpinned = true
+		}
+		if err := allowReachableCommits(dir); err != nil {
+			log.Printf("can't enable fetching pinned revisions from %s: %s", dir, err)
+		}
+		ms.list = append(ms.list, m)
+		ms.byName[m.Name] = m
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// allowReachableCommits sets uploadpack.allowReachableSHA1InWant in the
+// mirror at dir, if it's not already set, so clients can fetch revisions
+// which are not at the tip of any ref.
+func allowReachableCommits(dir string) error {
+	if allows, _ := git(dir, "config", "--bool", "--get", allowReachableKey); allows == "true" {
+		return nil
+	}
+	_, err := git(dir, "config", "--bool", allowReachableKey, "true")
+	return err
+}
+
+// Repo resolves the given request. Errors are returned
+// in lib.Repo.Error.
+func (s *Server) Repo(req *lib.RepoRequest) *lib.Repo {
+	mirrors, err := s.mirrors()
+	if err != nil {
+		return &lib.Repo{Path: req.Path, Error: err.Error()}
+	}
+	return s.repo(mirrors, req)
+}
+
+func (s *Server) repo(mirrors *mirrorSet, req *lib.RepoRequest) *lib.Repo {
+	m := mirrors.find(req.Path)
+	if m == nil {
+		return &lib.Repo{Path: req.Path, Error: "unknown package " + req.Path}
+	}
+	repo := &lib.Repo{
+		Path:                m.Path,
+		GoPkgsPath:          m.GoPkgsPath(),
+		DocumentationPrefix: s.DocumentationPrefix,
+	}
+	if repo.Path == "" {
+		repo.Path = repo.GoPkgsPath
+	}
+	repo.AllowsUnpinned = m.AllowsUnpinned
+	rev := "HEAD"
+	if req.Revision != "" {
+		if !lib.ValidRevision(req.Revision) {
+			repo.Error = "invalid revision " + req.Revision
+			return repo
+		}
+		rev = req.Revision
+	}
+	versions, err := gitVersions(m.Dir)
+	if err != nil {
+		repo.Error = err.Error()
+		return repo
+	}
+	versions = majorVersions(versions)
+	commit, err := resolveCommit(m.Dir, rev)
+	if err != nil || commit == "" {
+		repo.Error = "unknown revision " + rev
+		return repo
+	}
+	repo.Revision = lib.ShortRevision(commit)
+	if req.Revision == "" {
+		if len(versions) > 0 {
+			repo.Version = versions[len(versions)-1].Number
+		}
+	} else {
+		// Only return a version if it points exactly to
+		// the requested revision.
+		for _, v := range versions {
+			if v.Commit == commit {
+				repo.Version = v.Number
+			}
+		}
+	}
+	return repo
+}
+
+func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var reqs []*lib.RepoRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	mirrors, err := s.mirrors()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	repos := make([]*lib.Repo, len(reqs))
+	for ii, v := range reqs {
+		repos[ii] = s.repo(mirrors, v)
+	}
+	writeJSON(w, repos)
+}
+
+// Versions returns the version history of the repository
+// in the given request. Errors are returned in
+// lib.RepoVersions.Error.
+func (s *Server) Versions(req *lib.VersionsRequest) *lib.RepoVersions {
+	mirrors, err := s.mirrors()
+	if err != nil {
+		return &lib.RepoVersions{Path: req.Path, Error: err.Error()}
+	}
+	return s.versions(mirrors, req)
+}
+
+func (s *Server) versions(mirrors *mirrorSet, req *lib.VersionsRequest) *lib.RepoVersions {
+	m := mirrors.find(req.Path)
+	if m == nil {
+		return &lib.RepoVersions{Path: req.Path, Error: "unknown package " + req.Path}
+	}
+	rv := &lib.RepoVersions{
+		Path:       m.Path,
+		GoPkgsPath: m.GoPkgsPath(),
+	}
+	if rv.Path == "" {
+		rv.Path = rv.GoPkgsPath
+	}
+	versions, err := gitVersions(m.Dir)
+	if err != nil {
+		rv.Error = err.Error()
+		return rv
+	}
+	majors := make(map[*gitVersion]bool)
+	for _, v := range majorVersions(versions) {
+		majors[v] = true
+	}
+	hashes := make([]string, len(versions))
+	for ii, v := range versions {
+		hashes[ii] = v.Commit
+	}
+	commits, err := gitShow(m.Dir, hashes...)
+	if err != nil {
+		rv.Error = err.Error()
+		return rv
+	}
+	for _, v := range versions {
+		version := &lib.Version{
+			Number: v.Number,
+			Tag:    v.Ref[strings.LastIndex(v.Ref, "/")+1:],
+			Commit: lib.ShortRevision(v.Commit),
+			Major:  majors[v],
+		}
+		if c := commits[v.Commit]; c != nil {
+			version.Date = c.Date
+		}
+		rv.Versions = append(rv.Versions, version)
+	}
+	n := req.Revisions
+	if n <= 0 {
+		n = DefaultRevisions
+	}
+	if n > MaxRevisions {
+		n = MaxRevisions
+	}
+	history, err := gitLog(m.Dir, "HEAD", n)
+	if err != nil {
+		rv.Error = err.Error()
+		return rv
+	}
+	for _, v := range history {
+		rv.Revisions = append(rv.Revisions, &lib.Revision{
+			Commit:  lib.ShortRevision(v.Hash),
+			Date:    v.Date,
+			Subject: v.Subject,
+		})
+	}
+	return rv
+}
+
+func (s *Server) serveVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var reqs []*lib.VersionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	mirrors, err := s.mirrors()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	versions := make([]*lib.RepoVersions, len(reqs))
+	for ii, v := range reqs {
+		versions[ii] = s.versions(mirrors, v)
+	}
+	writeJSON(w, versions)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case infoPath:
+		s.serveInfo(w, r)
+	case versionsPath:
+		s.serveVersions(w, r)
+	case "/":
+		http.NotFound(w, r)
+	default:
+		s.servePackage(w, r)
+	}
+}
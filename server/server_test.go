@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+// runGit runs git with the given arguments at dir, failing
+// the test if it doesn't succeed, and returns its output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=gopkgs", "GIT_AUTHOR_EMAIL=gopkgs@example.com",
+		"GIT_COMMITTER_NAME=gopkgs", "GIT_COMMITTER_EMAIL=gopkgs@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// testMirror is a bare repository in a mirrors directory, together
+// with a checkout used for creating its commits.
+type testMirror struct {
+	t    *testing.T
+	Root string
+	Dir  string
+	work string
+}
+
+// newTestMirror creates a mirror named name.git, with the given
+// original import path, in a new mirrors directory.
+func newTestMirror(t *testing.T, name string, path string) *testMirror {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	tmp := t.TempDir()
+	m := &testMirror{
+		t:    t,
+		Root: filepath.Join(tmp, "mirrors"),
+		work: filepath.Join(tmp, "work"),
+	}
+	m.Dir = filepath.Join(m.Root, name+".git")
+	if err := os.MkdirAll(m.Root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, tmp, "init", "-q", "--bare", "-b", "master", m.Dir)
+	if path != "" {
+		runGit(t, m.Dir, "config", "gopkgs.path", path)
+	}
+	runGit(t, tmp, "init", "-q", "-b", "master", m.work)
+	runGit(t, m.work, "remote", "add", "origin", m.Dir)
+	return m
+}
+
+// Commit creates a new commit, optionally tagging it, and pushes
+// it to the mirror. It returns the hash of the commit.
+func (m *testMirror) Commit(subject string, tags ...string) string {
+	m.t.Helper()
+	runGit(m.t, m.work, "commit", "-q", "--allow-empty", "-m", subject)
+	for _, v := range tags {
+		runGit(m.t, m.work, "tag", v)
+	}
+	runGit(m.t, m.work, "push", "-q", "--tags", "origin", "HEAD:master")
+	return runGit(m.t, m.work, "rev-parse", "HEAD")
+}
+
+// post sends the given value as JSON to the given server path
+// and decodes the response into out, returning the status code.
+func post(t *testing.T, srv *httptest.Server, path string, in interface{}, out interface{}) int {
+	t.Helper()
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestServeInfo(t *testing.T) {
+	m := newTestMirror(t, "vfs", "github.com/rainycape/vfs")
+	first := m.Commit("one", "v1")
+	second := m.Commit("two", "v2.0")
+	latest := m.Commit("three")
+	s := New(m.Root)
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	reqs := []*lib.RepoRequest{
+		{Path: "github.com/rainycape/vfs/sub"},
+		{Path: "gopkgs.com/vfs.v1"},
+		{Path: "gopkgs.com/vfs", Revision: second},
+		{Path: "gopkgs.com/vfs", Revision: first[:8]},
+		{Path: "gopkgs.com/vfs", Revision: "--output=/tmp/x"},
+		{Path: "gopkgs.com/vfs", Revision: "master"},
+		{Path: "gopkgs.com/vfs", Revision: "0000000000"},
+		{Path: "github.com/unknown/repo"},
+	}
+	var repos []*lib.Repo
+	if code := post(t, srv, infoPath, reqs, &repos); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", code)
+	}
+	if len(repos) != len(reqs) {
+		t.Fatalf("expecting %d repos, got %d", len(reqs), len(repos))
+	}
+	want := []*lib.Repo{
+		{Path: "github.com/rainycape/vfs", GoPkgsPath: "gopkgs.com/vfs", Version: 2, Revision: latest[:RevisionLength]},
+		{Path: "github.com/rainycape/vfs", GoPkgsPath: "gopkgs.com/vfs", Version: 2, Revision: latest[:RevisionLength]},
+		{Path: "github.com/rainycape/vfs", GoPkgsPath: "gopkgs.com/vfs", Version: 2, Revision: second[:RevisionLength]},
+		{Path: "github.com/rainycape/vfs", GoPkgsPath: "gopkgs.com/vfs", Version: 1, Revision: first[:RevisionLength]},
+		{Error: "invalid revision --output=/tmp/x"},
+		{Error: "invalid revision master"},
+		{Error: "unknown revision 0000000000"},
+		{Error: "unknown package github.com/unknown/repo"},
+	}
+	for ii, v := range want {
+		got := repos[ii]
+		if v.Error != "" {
+			if got.Error != v.Error {
+				t.Errorf("request %d: expecting error %q, got %q", ii, v.Error, got.Error)
+			}
+			continue
+		}
+		if got.Error != "" {
+			t.Errorf("request %d: unexpected error %s", ii, got.Error)
+			continue
+		}
+		if got.Path != v.Path || got.GoPkgsPath != v.GoPkgsPath || got.Version != v.Version || got.Revision != v.Revision {
+			t.Errorf("request %d: got %+v, want %+v", ii, got, v)
+		}
+		if got.DocumentationPrefix != DefaultDocumentationPrefix {
+			t.Errorf("request %d: expecting documentation prefix %q, got %q", ii, DefaultDocumentationPrefix, got.DocumentationPrefix)
+		}
+	}
+}
+
+func TestServeInfoErrors(t *testing.T) {
+	m := newTestMirror(t, "vfs", "")
+	m.Commit("one")
+	s := New(m.Root)
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + infoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expecting status %d for GET, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+	resp, err = http.Post(srv.URL+infoPath, "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expecting status %d for invalid JSON, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestServeVersions(t *testing.T) {
+	m := newTestMirror(t, "vfs", "github.com/rainycape/vfs")
+	first := m.Commit("one", "v1")
+	second := m.Commit("two", "v2.0")
+	third := m.Commit("three")
+	s := New(m.Root)
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	var versions []*lib.RepoVersions
+	reqs := []*lib.VersionsRequest{{Path: "gopkgs.com/vfs", Revisions: 2}}
+	if code := post(t, srv, versionsPath, reqs, &versions); code != http.StatusOK {
+		t.Fatalf("unexpected status code %d", code)
+	}
+	rv := versions[0]
+	if rv.Error != "" {
+		t.Fatal(rv.Error)
+	}
+	if len(rv.Versions) != 2 || rv.Versions[0].Commit != first[:RevisionLength] || rv.Versions[1].Commit != second[:RevisionLength] {
+		t.Errorf("unexpected versions %+v", rv.Versions)
+	}
+	if len(rv.Revisions) != 2 || rv.Revisions[0].Commit != third[:RevisionLength] || rv.Revisions[0].Subject != "three" {
+		t.Errorf("unexpected revisions %+v", rv.Revisions)
+	}
+}
+
+func TestMirrorsRefresh(t *testing.T) {
+	m := newTestMirror(t, "vfs", "")
+	m.Commit("one")
+	s := New(m.Root)
+	s.RefreshInterval = 0
+	if _, err := s.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(m.Root, "other.git")
+	runGit(t, m.Root, "clone", "-q", "--bare", m.Dir, other)
+	if r := s.Repo(&lib.RepoRequest{Path: "gopkgs.com/other"}); r.Error == "" {
+		t.Error("new mirror found before refreshing")
+	}
+	if _, err := s.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if r := s.Repo(&lib.RepoRequest{Path: "gopkgs.com/other"}); r.Error != "" {
+		t.Errorf("new mirror not found after refreshing: %s", r.Error)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// importConflict represents a place where values with types from a package
+// which would be rewritten meet another package which still imports
+// its original import path. Rewriting the import would either break
+// the build or, even worse, change the behavior at runtime (e.g. a type
+// assertion which stops matching).
+type importConflict struct {
+	// Pos is the position of the conflicting expression
+	Pos token.Position
+	// Kind is the kind of conflicting expression
+	Kind conflictKind
+	// Reason describes the conflict
+	Reason string
+}
+
+// conflictKind identifies the kind of expression causing
+// an importConflict.
+type conflictKind string
+
+const (
+	conflictTypeAssertion       conflictKind = "type assertion"
+	conflictTypeSwitch          conflictKind = "type switch"
+	conflictField               conflictKind = "field"
+	conflictInterfaceAssignment conflictKind = "interface assignment"
+	conflictReflect             conflictKind = "reflect"
+	conflictCall                conflictKind = "call"
+	conflictArgument            conflictKind = "argument"
+)
+
+func (c *importConflict) String() string {
+	return fmt.Sprintf("%s:%d: %s", c.Pos.Filename, c.Pos.Line, c.Reason)
+}
+
+// mentionsPackage returns true iff the given type, or any of the types it's
+// composed of, is declared in the package with the given import path.
+func mentionsPackage(t types.Type, path string) bool {
+	return typeMentions(t, path, make(map[types.Type]bool))
+}
+
+func typeMentions(t types.Type, path string, seen map[types.Type]bool) bool {
+	if t == nil || seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch x := t.(type) {
+	case *types.Named:
+		if obj := x.Obj(); obj != nil && obj.Pkg() != nil && obj.Pkg().Path() == path {
+			return true
+		}
+		if args := x.TypeArgs(); args != nil {
+			for ii := 0; ii < args.Len(); ii++ {
+				if typeMentions(args.At(ii), path, seen) {
+					return true
+				}
+			}
+		}
+		// Don't look into the underlying type, named types
+		// from other packages are an API boundary on their own.
+		return false
+	case *types.Alias:
+		return typeMentions(types.Unalias(x), path, seen)
+	case *types.Pointer:
+		return typeMentions(x.Elem(), path, seen)
+	case *types.Slice:
+		return typeMentions(x.Elem(), path, seen)
+	case *types.Array:
+		return typeMentions(x.Elem(), path, seen)
+	case *types.Chan:
+		return typeMentions(x.Elem(), path, seen)
+	case *types.Map:
+		return typeMentions(x.Key(), path, seen) || typeMentions(x.Elem(), path, seen)
+	case *types.Tuple:
+		for ii := 0; ii < x.Len(); ii++ {
+			if typeMentions(x.At(ii).Type(), path, seen) {
+				return true
+			}
+		}
+	case *types.Signature:
+		return typeMentions(x.Params(), path, seen) || typeMentions(x.Results(), path, seen)
+	case *types.Struct:
+		for ii := 0; ii < x.NumFields(); ii++ {
+			if typeMentions(x.Field(ii).Type(), path, seen) {
+				return true
+			}
+		}
+	case *types.Interface:
+		for ii := 0; ii < x.NumMethods(); ii++ {
+			if typeMentions(x.Method(ii).Type(), path, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameRepository returns true iff both import paths belong
+// to the same 3rd party repository.
+func sameRepository(p1, p2 string) bool {
+	r1, _ := splitRepository(p1)
+	r2, _ := splitRepository(p2)
+	return r1 != "" && r1 == r2
+}
+
+// dependentPackages returns, for each of the given import paths, the packages
+// imported (directly or indirectly) by pkg which also import it. Packages
+// in the same repository as the import are not included.
+func dependentPackages(pkg *types.Package, paths map[string]bool) map[string]map[*types.Package]bool {
+	// Transitive imports of every package
+	reach := make(map[*types.Package]map[string]bool)
+	var visit func(p *types.Package) map[string]bool
+	visit = func(p *types.Package) map[string]bool {
+		if r, ok := reach[p]; ok {
+			return r
+		}
+		r := make(map[string]bool)
+		reach[p] = r
+		for _, imp := range p.Imports() {
+			r[imp.Path()] = true
+			for k := range visit(imp) {
+				r[k] = true
+			}
+		}
+		return r
+	}
+	visit(pkg)
+	deps := make(map[string]map[*types.Package]bool)
+	for path := range paths {
+		for p, r := range reach {
+			if p == pkg || p.Path() == path || sameRepository(p.Path(), path) || !r[path] {
+				continue
+			}
+			if deps[path] == nil {
+				deps[path] = make(map[*types.Package]bool)
+			}
+			deps[path][p] = true
+		}
+	}
+	return deps
+}
+
+// calledFunc returns the function or method called by
+// the given expression, if it can be statically determined.
+func calledFunc(info *types.Info, fun ast.Expr) *types.Func {
+	var ident *ast.Ident
+	switch x := fun.(type) {
+	case *ast.Ident:
+		ident = x
+	case *ast.SelectorExpr:
+		ident = x.Sel
+	case *ast.ParenExpr:
+		return calledFunc(info, x.X)
+	case *ast.IndexExpr:
+		return calledFunc(info, x.X)
+	}
+	if ident == nil {
+		return nil
+	}
+	fn, _ := info.Uses[ident].(*types.Func)
+	return fn
+}
+
+// typeChecker finds conflicts in a type checked package.
+type typeChecker struct {
+	fset      *token.FileSet
+	info      *types.Info
+	paths     map[string]bool
+	deps      map[string]map[*types.Package]bool
+	conflicts map[string][]*importConflict
+}
+
+func (t *typeChecker) add(path string, kind conflictKind, node ast.Node, reason string, args ...interface{}) {
+	t.conflicts[path] = append(t.conflicts[path], &importConflict{
+		Pos:    t.fset.Position(node.Pos()),
+		Kind:   kind,
+		Reason: fmt.Sprintf(reason, args...),
+	})
+}
+
+func (t *typeChecker) typeOf(expr ast.Expr) types.Type {
+	if tv, ok := t.info.Types[expr]; ok {
+		return tv.Type
+	}
+	return nil
+}
+
+// isDependent returns true iff pkg imports, directly or
+// indirectly, the given import path.
+func (t *typeChecker) isDependent(path string, pkg *types.Package) bool {
+	return pkg != nil && t.deps[path][pkg]
+}
+
+func (t *typeChecker) checkNode(n ast.Node) {
+	for path := range t.paths {
+		if len(t.deps[path]) == 0 {
+			// No other package uses this one, values with
+			// its types can only come from the package
+			// being rewritten.
+			continue
+		}
+		switch x := n.(type) {
+		case *ast.TypeAssertExpr:
+			if x.Type != nil {
+				if typ := t.typeOf(x.Type); mentionsPackage(typ, path) {
+					t.add(path, conflictTypeAssertion, x, "type assertion to %s, the value might come from a package which imports %s", typ, path)
+				}
+			}
+		case *ast.TypeSwitchStmt:
+			for _, stmt := range x.Body.List {
+				if cc, ok := stmt.(*ast.CaseClause); ok {
+					for _, expr := range cc.List {
+						if typ := t.typeOf(expr); mentionsPackage(typ, path) {
+							t.add(path, conflictTypeSwitch, expr, "type switch case %s, the value might come from a package which imports %s", typ, path)
+						}
+					}
+				}
+			}
+		case *ast.CallExpr:
+			t.checkCall(path, x)
+		case *ast.SelectorExpr:
+			if sel := t.info.Selections[x]; sel != nil {
+				if v, ok := sel.Obj().(*types.Var); ok && v.IsField() && t.isDependent(path, v.Pkg()) && mentionsPackage(v.Type(), path) {
+					t.add(path, conflictField, x, "field %s from package %s has type %s", v.Name(), v.Pkg().Path(), v.Type())
+				}
+			}
+		case *ast.AssignStmt:
+			if len(x.Lhs) == len(x.Rhs) {
+				for ii, lhs := range x.Lhs {
+					t.checkInterfaceAssignment(path, x.Rhs[ii], t.typeOf(lhs))
+				}
+			}
+		case *ast.ValueSpec:
+			if x.Type != nil {
+				typ := t.typeOf(x.Type)
+				for _, v := range x.Values {
+					t.checkInterfaceAssignment(path, v, typ)
+				}
+			}
+		}
+	}
+}
+
+// checkInterfaceAssignment checks if a value with a type from path is being
+// assigned to an interface declared in a package which imports path.
+func (t *typeChecker) checkInterfaceAssignment(path string, value ast.Expr, to types.Type) {
+	named, ok := to.(*types.Named)
+	if !ok || !types.IsInterface(named) || !t.isDependent(path, named.Obj().Pkg()) {
+		return
+	}
+	if typ := t.typeOf(value); mentionsPackage(typ, path) {
+		t.add(path, conflictInterfaceAssignment, value, "value of type %s assigned to interface %s, declared in a package which imports %s", typ, named, path)
+	}
+}
+
+func (t *typeChecker) checkCall(path string, call *ast.CallExpr) {
+	fn := calledFunc(t.info, call.Fun)
+	if fn == nil || fn.Pkg() == nil {
+		return
+	}
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok {
+		return
+	}
+	fnPkg := fn.Pkg()
+	if fnPkg.Path() == "reflect" {
+		for _, arg := range call.Args {
+			if typ := t.typeOf(arg); mentionsPackage(typ, path) {
+				t.add(path, conflictReflect, arg, "value of type %s passed to reflect.%s, its reflected type won't match the one used by packages which import %s", typ, fn.Name(), path)
+			}
+		}
+		return
+	}
+	if !t.isDependent(path, fnPkg) {
+		return
+	}
+	name := fnPkg.Path() + "." + fn.Name()
+	if mentionsPackage(sig.Params(), path) || mentionsPackage(sig.Results(), path) {
+		t.add(path, conflictCall, call, "call to %s, whose signature uses types from %s", name, path)
+		return
+	}
+	// Values passed as interfaces might be type asserted
+	// by the called function.
+	for _, arg := range call.Args {
+		if typ := t.typeOf(arg); mentionsPackage(typ, path) {
+			t.add(path, conflictArgument, arg, "value of type %s passed to %s, which imports %s", typ, name, path)
+		}
+	}
+}
+
+// testImporter imports packages from source, except for the package
+// under test, which is returned as type checked with its test files.
+// This way, the external test package can use the identifiers exported
+// only to tests (e.g. in export_test.go).
+type testImporter struct {
+	from types.ImporterFrom
+	pkg  *types.Package
+}
+
+func (t *testImporter) Import(path string) (*types.Package, error) {
+	return t.ImportFrom(path, "", 0)
+}
+
+func (t *testImporter) ImportFrom(path string, dir string, mode types.ImportMode) (*types.Package, error) {
+	if t.pkg != nil && path == t.pkg.Path() {
+		return t.pkg, nil
+	}
+	return t.from.ImportFrom(path, dir, mode)
+}
+
+// typeConflicts type checks the given files, which must belong to pkg, and
+// returns the conflicts found for each of the given import paths. If the
+// package can't be type checked, an error is returned.
+func typeConflicts(fset *token.FileSet, pkg *build.Package, files map[string]*ast.File, paths map[string]bool) (map[string][]*importConflict, error) {
+	// Files in the external test package must be checked separately
+	byPackage := make(map[string][]*ast.File)
+	var names []string
+	for k := range files {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var pkgNames []string
+	for _, v := range names {
+		f := files[v]
+		if byPackage[f.Name.Name] == nil {
+			pkgNames = append(pkgNames, f.Name.Name)
+		}
+		byPackage[f.Name.Name] = append(byPackage[f.Name.Name], f)
+	}
+	// Check the package itself first, so the external test
+	// package can import it with its test files included.
+	sort.SliceStable(pkgNames, func(i, j int) bool { return pkgNames[i] == pkg.Name && pkgNames[j] != pkg.Name })
+	conflicts := make(map[string][]*importConflict)
+	imp := &testImporter{from: importer.ForCompiler(fset, "source", nil).(types.ImporterFrom)}
+	for _, name := range pkgNames {
+		pkgFiles := byPackage[name]
+		var typeErrors []string
+		conf := &types.Config{
+			Importer:    imp,
+			FakeImportC: true,
+			Error: func(err error) {
+				typeErrors = append(typeErrors, err.Error())
+			},
+		}
+		info := &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		}
+		importPath := pkg.ImportPath
+		if strings.HasSuffix(name, "_test") && name != pkg.Name {
+			importPath += "_test"
+		}
+		tpkg, _ := conf.Check(importPath, fset, pkgFiles, info)
+		if len(typeErrors) > 0 {
+			return nil, errors.New(typeErrors[0])
+		}
+		if importPath == pkg.ImportPath {
+			imp.pkg = tpkg
+		}
+		tc := &typeChecker{
+			fset:      fset,
+			info:      info,
+			paths:     paths,
+			deps:      dependentPackages(tpkg, paths),
+			conflicts: conflicts,
+		}
+		for _, f := range pkgFiles {
+			ast.Inspect(f, func(n ast.Node) bool {
+				if n != nil {
+					tc.checkNode(n)
+				}
+				return true
+			})
+		}
+	}
+	return conflicts, nil
+}
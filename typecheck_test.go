@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeGOPATH writes the given files, keyed by their path relative
+// to GOPATH/src, into a new GOPATH which is used until the test
+// finishes.
+func writeGOPATH(t *testing.T, files map[string]string) string {
+	t.Helper()
+	gopath := t.TempDir()
+	for k, v := range files {
+		p := filepath.Join(gopath, "src", filepath.FromSlash(k))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setGOPATH(t, gopath)
+	return gopath
+}
+
+// parsePackage imports and parses the package with the given
+// import path, including its tests.
+func parsePackage(t *testing.T, importPath string) (*token.FileSet, *build.Package, map[string]*ast.File) {
+	t.Helper()
+	pkg, err := build.Import(importPath, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	files, err := parseFiles(fset, pkg.Dir, packageFiles(pkg, false), parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fset, pkg, files
+}
+
+func TestTypeConflictsExternalTestHooks(t *testing.T) {
+	dir := t.TempDir()
+	sources := map[string]string{
+		"a.go":           "package a\n\nfunc double(x int) int { return 2 * x }\n",
+		"export_test.go": "package a\n\nvar Double = double\n",
+		"a_test.go":      "package a_test\n\nimport (\n\t\"testing\"\n\n\t\"example.com/a\"\n)\n\nfunc TestDouble(t *testing.T) {\n\tif a.Double(2) != 4 {\n\t\tt.Fail()\n\t}\n}\n",
+	}
+	var names []string
+	for k, v := range sources {
+		if err := os.WriteFile(filepath.Join(dir, k), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, k)
+	}
+	pkg := &build.Package{Name: "a", ImportPath: "example.com/a", Dir: dir}
+	fset := token.NewFileSet()
+	files, err := parseFiles(fset, dir, names, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]bool{"github.com/c/d": true}
+	conflicts, err := typeConflicts(fset, pkg, files, paths)
+	if err != nil {
+		t.Fatalf("can't type check package with external test using hooks from export_test.go: %s", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("expecting no conflicts, got %v", conflicts)
+	}
+}
+
+func TestTypeConflicts(t *testing.T) {
+	const (
+		orig   = "github.com/orig/x"
+		header = "package a\n\nimport (\n\t\"reflect\"\n\n\t\"github.com/dep/dep\"\n\t\"github.com/orig/x\"\n)\n\nvar _ = reflect.TypeOf\nvar _ = dep.Get\nvar _ x.T\n\n"
+	)
+	tests := []struct {
+		name   string
+		src    string
+		kind   conflictKind
+		line   int
+		reason string
+	}{
+		{
+			"type assertion",
+			"var _, IsT = dep.Get().(x.T)\n",
+			conflictTypeAssertion,
+			14,
+			"type assertion to github.com/orig/x.T, the value might come from a package which imports github.com/orig/x",
+		},
+		{
+			"type switch",
+			"func F() {\n\tswitch dep.Get().(type) {\n\tcase x.T:\n\t}\n}\n",
+			conflictTypeSwitch,
+			16,
+			"type switch case github.com/orig/x.T, the value might come from a package which imports github.com/orig/x",
+		},
+		{
+			"interface assignment",
+			"var H dep.Handler = x.T{}\n",
+			conflictInterfaceAssignment,
+			14,
+			"value of type github.com/orig/x.T assigned to interface github.com/dep/dep.Handler, declared in a package which imports github.com/orig/x",
+		},
+		{
+			"reflect",
+			"var Type = reflect.TypeOf(x.T{})\n",
+			conflictReflect,
+			14,
+			"value of type github.com/orig/x.T passed to reflect.TypeOf, its reflected type won't match the one used by packages which import github.com/orig/x",
+		},
+		{
+			"call with signature",
+			"func F() {\n\tdep.Take(x.T{})\n}\n",
+			conflictCall,
+			15,
+			"call to github.com/dep/dep.Take, whose signature uses types from github.com/orig/x",
+		},
+		{
+			"argument",
+			"func F() {\n\tdep.Use(x.T{})\n}\n",
+			conflictArgument,
+			15,
+			"value of type github.com/orig/x.T passed to github.com/dep/dep.Use, which imports github.com/orig/x",
+		},
+		{
+			"field",
+			"var F = dep.Holder{}.Value\n",
+			conflictField,
+			14,
+			"field Value from package github.com/dep/dep has type github.com/orig/x.T",
+		},
+		{
+			"no conflict",
+			"var T x.T\n",
+			"",
+			0,
+			"",
+		},
+	}
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			writeGOPATH(t, map[string]string{
+				orig + "/x.go":              "package x\n\ntype T struct{}\n\nfunc (T) Handle() {}\n",
+				"github.com/dep/dep/dep.go": "package dep\n\nimport \"github.com/orig/x\"\n\ntype Handler interface {\n\tHandle()\n}\n\ntype Holder struct {\n\tValue x.T\n}\n\nfunc Get() interface{} { return x.T{} }\n\nfunc Take(t x.T) {}\n\nfunc Use(v interface{}) {}\n",
+				"example.com/a/a.go":        header + v.src,
+			})
+			fset, pkg, files := parsePackage(t, "example.com/a")
+			conflicts, err := typeConflicts(fset, pkg, files, map[string]bool{orig: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := conflicts[orig]
+			if v.kind == "" {
+				if len(found) != 0 {
+					t.Errorf("expecting no conflicts, got %v", found)
+				}
+				return
+			}
+			if len(found) != 1 {
+				t.Fatalf("expecting 1 conflict, got %v", found)
+			}
+			if c := found[0]; c.Kind != v.kind {
+				t.Errorf("expecting conflict kind %q, got %q", v.kind, c.Kind)
+			}
+			// Check the explanation printed when keeping the import
+			rec := &record{}
+			disabled, err := keptImports(fset, pkg, files, func(p string) bool { return p == orig }, false, rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !disabled[orig] {
+				t.Errorf("expecting %s to be kept", orig)
+			}
+			want := fmt.Sprintf("%s:%d: %s", filepath.Join(pkg.Dir, "a.go"), v.line, v.reason)
+			if len(rec.Skipped) != 1 || rec.Skipped[0].Reason != want {
+				t.Errorf("expecting explanation %q, got %+v", want, rec.Skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"log"
+	"path/filepath"
+	"strconv"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+
+	"code.google.com/p/go.tools/astutil"
+)
+
+type unrewriteOptions struct {
+	Interactive bool `name:"i" help:"Interactive mode"`
+	DryRun      bool `name:"n" help:"Dry run - only show the changes that would be made"`
+	Diff        bool `name:"d" help:"Print a unified diff of the changes rather than rewriting the files"`
+	Verbose     bool `name:"v" help:"Verbose output"`
+	NoTests     bool `name:"notests" help:"Don't rewrite imports in test files"`
+}
+
+func unrewritePackage(pkg *build.Package, st *rewriteState, opts *unrewriteOptions) {
+	rec := &record{Command: "unrewrite", Package: pkg.ImportPath}
+	if err := doUnrewritePackage(pkg, st, opts, rec); err != nil {
+		log.Printf("error unrewriting package %s: %s", pkgName(pkg), err)
+		rec.SetError(err)
+	}
+	emit(rec)
+}
+
+func doUnrewritePackage(pkg *build.Package, st *rewriteState, opts *unrewriteOptions, rec *record) error {
+	abs, err := filepath.Abs(pkg.Dir)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	files, err := parseFiles(fset, abs, packageFiles(pkg, opts.NoTests), parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	using := make(map[string]bool)
+	for _, v := range files {
+		imports := astutil.Imports(fset, v)
+		for _, group := range imports {
+			for _, imp := range group {
+				if unquoted, err := strconv.Unquote(imp.Path.Value); err == nil {
+					if imp, err := lib.ParseImportPath(unquoted); err == nil {
+						using[imp.Base()] = true
+					}
+				}
+			}
+		}
+	}
+	if len(using) == 0 {
+		return nil
+	}
+	var names []string
+	for k := range using {
+		names = append(names, k)
+	}
+	if opts.Verbose {
+		fmt.Fprintf(stdout, "package %s uses %d gopkgs.com repositories: %v\n", pkgName(pkg), len(names), names)
+	}
+	repos, err := st.RequestRepos(names)
+	if err != nil {
+		return err
+	}
+	rec.Repos = repos
+	// Map from path at gopkgs.com to original path
+	originals := make(map[string]string)
+	for ii, v := range repos {
+		name := names[ii]
+		if v.Error != "" || v.Path == "" || v.Path == name {
+			if opts.Verbose {
+				fmt.Fprintf(stdout, "ignoring %s, can't find original import path: %s\n", name, v.Error)
+			}
+			reason := "can't find original import path"
+			if v.Error != "" {
+				reason += ": " + v.Error
+			}
+			rec.Skip(name, reason)
+			continue
+		}
+		if opts.Interactive && !confirm(fmt.Sprintf("rewrite import %s to %s in package %s?", name, v.Path, pkgName(pkg))) {
+			rec.Skip(name, "declined by the user")
+			continue
+		}
+		originals[name] = v.Path
+	}
+	if len(originals) == 0 {
+		return nil
+	}
+	mapImport := func(p string) string {
+		imp, err := lib.ParseImportPath(p)
+		if err != nil {
+			return ""
+		}
+		if orig := originals[imp.Base()]; orig != "" {
+			return orig + imp.Subpackage
+		}
+		return ""
+	}
+	return rewriteImports(fset, files, mapImport, st, rewriteMode{DryRun: opts.DryRun, Diff: opts.Diff, Verbose: opts.Verbose}, rec)
+}
+
+func unrewriteSubcommand(args []string, opts *unrewriteOptions) error {
+	pkgs, err := importPackages(args)
+	if err != nil {
+		return err
+	}
+	st := new(rewriteState)
+	for _, pkg := range sortPackages(pkgs) {
+		unrewritePackage(pkg, st, opts)
+	}
+	return nil
+}
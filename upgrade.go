@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+
+	"code.google.com/p/go.tools/astutil"
+)
+
+type upgradeOptions struct {
+	Interactive     bool     `name:"i" help:"Interactive mode"`
+	PreferRevisions bool     `name:"r" help:"Switch imports pinned on versions to revisions"`
+	Library         autoBool `name:"lib" help:"[auto|true|false]: Library mode - refuse to pin packages on revisions, only on versions"`
+	DryRun          bool     `name:"n" help:"Dry run - only show the changes that would be made"`
+	Diff            bool     `name:"d" help:"Print a unified diff of the changes rather than rewriting the files"`
+	Verbose         bool     `name:"v" help:"Verbose output"`
+	NoTests         bool     `name:"notests" help:"Don't rewrite imports in test files"`
+	Repo            string   `name:"repo" help:"Only upgrade imports from this repository"`
+	Version         int      `name:"version" help:"Upgrade to this version rather than the latest one, requires -repo"`
+	Revision        string   `name:"revision" help:"Upgrade to this revision rather than the latest one, requires -repo"`
+}
+
+func (opts *upgradeOptions) LibraryMode(pkg *build.Package) bool {
+	if opts.Library.Auto() {
+		return pkg.Name != "main"
+	}
+	return opts.Library.Bool()
+}
+
+// upgradePath returns the import path at gopkgs.com, including the version
+// or revision, which imports pinned as imp should be upgraded to. Imports
+// keep their kind of pin unless asked to switch, either by -version and
+// -revision, by -r for imports pinned on a version or by library mode for
+// imports pinned on a revision. If they should be left untouched (e.g. they
+// are already pinned on the chosen version or a newer one), an error
+// describing the reason is returned.
+func upgradePath(imp *lib.ImportPath, repo *lib.Repo, libraryMode bool, opts *upgradeOptions) (string, error) {
+	if opts.Revision != "" {
+		if libraryMode {
+			return "", fmt.Errorf("not pinning on revision %s in library mode", opts.Revision)
+		}
+		return revisionUpgradePath(imp, repo.GoPkgsPath, opts.Revision)
+	}
+	version := opts.Version
+	if version > repo.Version {
+		return "", fmt.Errorf("version %d is not available, the latest one is %d", version, repo.Version)
+	}
+	if version == 0 {
+		if !libraryMode && (imp.Revision != "" || opts.PreferRevisions) {
+			if repo.Revision == "" {
+				return "", errors.New("no revisions available")
+			}
+			return revisionUpgradePath(imp, repo.GoPkgsPath, repo.Revision)
+		}
+		if repo.Version == 0 {
+			return "", errors.New("no versions available")
+		}
+		version = repo.Version
+	}
+	if imp.Version == version {
+		return "", fmt.Errorf("already pinned on version %d", version)
+	}
+	if imp.Version > version {
+		return "", fmt.Errorf("version %d is older than the current one (%d)", version, imp.Version)
+	}
+	return repo.GoPkgsPath + ".v" + strconv.Itoa(version), nil
+}
+
+// revisionUpgradePath returns the import path for pinning the repository
+// at base on rev, or an error if imp is already pinned on it or rev is
+// not a valid revision.
+func revisionUpgradePath(imp *lib.ImportPath, base string, rev string) (string, error) {
+	if !lib.ValidRevision(rev) {
+		return "", fmt.Errorf("invalid revision %q", rev)
+	}
+	if imp.Revision != "" && (strings.HasPrefix(rev, imp.Revision) || strings.HasPrefix(imp.Revision, rev)) {
+		return "", fmt.Errorf("already pinned on revision %s", imp.Revision)
+	}
+	return base + ".r" + rev, nil
+}
+
+// isPinnedImport returns true iff p is a gopkgs.com
+// import path pinned on a version or revision.
+func isPinnedImport(p string) bool {
+	imp, err := lib.ParseImportPath(p)
+	return err == nil && imp.Pinned()
+}
+
+func upgradePackage(pkg *build.Package, st *rewriteState, opts *upgradeOptions) {
+	rec := &record{Command: "upgrade", Package: pkg.ImportPath}
+	if err := doUpgradePackage(pkg, st, opts, rec); err != nil {
+		log.Printf("error upgrading package %s: %s", pkgName(pkg), err)
+		rec.SetError(err)
+	}
+	emit(rec)
+}
+
+func doUpgradePackage(pkg *build.Package, st *rewriteState, opts *upgradeOptions, rec *record) error {
+	libraryMode := opts.LibraryMode(pkg)
+	abs, err := filepath.Abs(pkg.Dir)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	files, err := parseFiles(fset, abs, packageFiles(pkg, opts.NoTests), parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	// As in rewrite, imports whose values meet packages which
+	// still import the current version can't be upgraded.
+	disabled, err := keptImports(fset, pkg, files, isPinnedImport, opts.Verbose, rec)
+	if err != nil {
+		return err
+	}
+	// Pins in use for each repository, keyed by the import
+	// path of the repository including the pin.
+	using := make(map[string]map[string]*lib.ImportPath)
+	for _, v := range files {
+		imports := astutil.Imports(fset, v)
+		for _, group := range imports {
+			for _, imp := range group {
+				if unquoted, err := strconv.Unquote(imp.Path.Value); err == nil && !disabled[unquoted] {
+					gimp, err := lib.ParseImportPath(unquoted)
+					if err != nil || !gimp.Pinned() {
+						continue
+					}
+					base := gimp.Base()
+					if using[base] == nil {
+						using[base] = make(map[string]*lib.ImportPath)
+					}
+					using[base][gimp.Root()] = gimp
+				}
+			}
+		}
+	}
+	var names []string
+	for k := range using {
+		names = append(names, k)
+	}
+	if opts.Repo != "" {
+		var filtered []string
+		for _, v := range names {
+			if v == opts.Repo {
+				filtered = append(filtered, v)
+			}
+		}
+		names = filtered
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	repos, err := st.RequestRepos(names)
+	if err != nil {
+		return err
+	}
+	rec.Repos = repos
+	// Keyed by the import path of the repository, including the pin
+	upgrades := make(map[string]string)
+	for ii, v := range repos {
+		name := names[ii]
+		if v.Error != "" {
+			fmt.Fprintf(stdout, "ignoring %s: %s\n", name, v.Error)
+			rec.Skip(name, v.Error)
+			continue
+		}
+		var roots []string
+		for k := range using[name] {
+			roots = append(roots, k)
+		}
+		sort.Strings(roots)
+		for _, root := range roots {
+			importPath, err := upgradePath(using[name][root], v, libraryMode, opts)
+			if err != nil {
+				if opts.Verbose {
+					fmt.Fprintf(stdout, "ignoring %s: %s\n", root, err)
+				}
+				rec.Skip(root, err.Error())
+				continue
+			}
+			if opts.Interactive && !confirm(fmt.Sprintf("upgrade imports from %s to %s in package %s?", root, importPath, pkgName(pkg))) {
+				rec.Skip(root, "declined by the user")
+				continue
+			}
+			upgrades[root] = importPath
+		}
+	}
+	if len(upgrades) == 0 {
+		return nil
+	}
+	mapImport := func(p string) string {
+		if disabled[p] {
+			return ""
+		}
+		imp, err := lib.ParseImportPath(p)
+		if err != nil || !imp.Pinned() {
+			return ""
+		}
+		if up := upgrades[imp.Root()]; up != "" {
+			return up + imp.Subpackage
+		}
+		return ""
+	}
+	return rewriteImports(fset, files, mapImport, st, rewriteMode{DryRun: opts.DryRun, Diff: opts.Diff, Verbose: opts.Verbose}, rec)
+}
+
+// checkUpgradeTarget checks that the version or revision requested
+// with -version or -revision exists in the repository set by -repo.
+func checkUpgradeTarget(opts *upgradeOptions) error {
+	switch {
+	case opts.Version > 0:
+		resp, err := Versions([]*lib.VersionsRequest{{Path: opts.Repo}})
+		if err != nil {
+			return err
+		}
+		if resp[0].Error != "" {
+			return fmt.Errorf("%s: %s", opts.Repo, resp[0].Error)
+		}
+		for _, v := range resp[0].Versions {
+			if v.Number == opts.Version {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s has no version %d", opts.Repo, opts.Version)
+	case opts.Revision != "":
+		if _, err := Repo(&lib.RepoRequest{Path: opts.Repo, Revision: opts.Revision}); err != nil {
+			return fmt.Errorf("%s: %s", opts.Repo, err)
+		}
+	}
+	return nil
+}
+
+func upgradeSubcommand(args []string, opts *upgradeOptions) error {
+	if (opts.Version > 0 || opts.Revision != "") && opts.Repo == "" {
+		return fmt.Errorf("-version and -revision require -repo")
+	}
+	if opts.Version > 0 && opts.Revision != "" {
+		return fmt.Errorf("-version and -revision are mutually exclusive")
+	}
+	if opts.Revision != "" && !lib.ValidRevision(opts.Revision) {
+		return fmt.Errorf("invalid revision %q, it must be a commit hash or a prefix of at least %d characters", opts.Revision, lib.MinRevisionLength)
+	}
+	if opts.Repo != "" {
+		imp, err := lib.ParseImportPath(opts.Repo)
+		if err != nil {
+			return err
+		}
+		opts.Repo = imp.Base()
+		if err := checkUpgradeTarget(opts); err != nil {
+			return err
+		}
+	}
+	pkgs, err := importPackages(args)
+	if err != nil {
+		return err
+	}
+	st := new(rewriteState)
+	for _, pkg := range sortPackages(pkgs) {
+		upgradePackage(pkg, st, opts)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+func TestUpgradePath(t *testing.T) {
+	repo := &lib.Repo{GoPkgsPath: "gopkgs.com/vfs", Version: 2, Revision: "0123456789ab"}
+	unversioned := &lib.Repo{GoPkgsPath: "gopkgs.com/vfs", Revision: "0123456789ab"}
+	tests := []struct {
+		imp     string
+		repo    *lib.Repo
+		library bool
+		opts    upgradeOptions
+		want    string
+	}{
+		{"gopkgs.com/vfs.v1", repo, false, upgradeOptions{}, "gopkgs.com/vfs.v2"},
+		{"gopkgs.com/vfs.v1", repo, true, upgradeOptions{}, "gopkgs.com/vfs.v2"},
+		{"gopkgs.com/vfs.v2", repo, false, upgradeOptions{}, ""},
+		{"gopkgs.com/vfs.v3", repo, false, upgradeOptions{}, ""},
+		// Version pins are never turned into revision pins implicitly
+		{"gopkgs.com/vfs.v1", unversioned, false, upgradeOptions{}, ""},
+		{"gopkgs.com/vfs.v1", repo, false, upgradeOptions{PreferRevisions: true}, "gopkgs.com/vfs.r0123456789ab"},
+		{"gopkgs.com/vfs.v1", repo, true, upgradeOptions{PreferRevisions: true}, "gopkgs.com/vfs.v2"},
+		{"gopkgs.com/vfs.r000000000000", repo, false, upgradeOptions{}, "gopkgs.com/vfs.r0123456789ab"},
+		{"gopkgs.com/vfs.r0123456789ab", repo, false, upgradeOptions{}, ""},
+		{"gopkgs.com/vfs.r000000000000", repo, true, upgradeOptions{}, "gopkgs.com/vfs.v2"},
+		{"gopkgs.com/vfs.r000000000000", unversioned, true, upgradeOptions{}, ""},
+		{"gopkgs.com/vfs.v1", repo, false, upgradeOptions{Version: 3}, ""},
+		{"gopkgs.com/vfs.r000000000000", unversioned, false, upgradeOptions{Version: 1}, ""},
+		{"gopkgs.com/vfs.v2", repo, false, upgradeOptions{Version: 1}, ""},
+		{"gopkgs.com/vfs.r000000000000", repo, false, upgradeOptions{Version: 1}, "gopkgs.com/vfs.v1"},
+		{"gopkgs.com/vfs.v1", repo, false, upgradeOptions{Revision: "abcdef"}, "gopkgs.com/vfs.rabcdef"},
+		{"gopkgs.com/vfs.v1", repo, false, upgradeOptions{Revision: "master"}, ""},
+		{"gopkgs.com/vfs.v1", repo, false, upgradeOptions{Revision: "abc"}, ""},
+		{"gopkgs.com/vfs.rabcdef", repo, false, upgradeOptions{Revision: "abcdef0123"}, ""},
+		{"gopkgs.com/vfs.v1", repo, true, upgradeOptions{Revision: "abcdef"}, ""},
+	}
+	for _, v := range tests {
+		imp, err := lib.ParseImportPath(v.imp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := upgradePath(imp, v.repo, v.library, &v.opts)
+		if v.want == "" {
+			if err == nil {
+				t.Errorf("upgradePath(%s, %+v, library %v) = %s, want error", v.imp, v.opts, v.library, got)
+			}
+			continue
+		}
+		if err != nil || got != v.want {
+			t.Errorf("upgradePath(%s, %+v, library %v) = %q, %v, want %q", v.imp, v.opts, v.library, got, err, v.want)
+			continue
+		}
+		// The new import path must be readable
+		up, err := lib.ParseImportPath(got)
+		if err != nil || !up.Pinned() || up.Root() != got {
+			t.Errorf("upgradePath(%s, %+v, library %v) = %q, which can't be parsed back: %v", v.imp, v.opts, v.library, got, err)
+		}
+	}
+}
+
+func TestUpgradeKeptImports(t *testing.T) {
+	writeGOPATH(t, map[string]string{
+		"gopkgs.com/x.v1/x.go":      "package x\n\ntype T struct{}\n",
+		"gopkgs.com/y.v1/y.go":      "package y\n\ntype T struct{}\n",
+		"github.com/dep/dep/dep.go": "package dep\n\nimport \"gopkgs.com/x.v1\"\n\nfunc Get() interface{} { return x.T{} }\n",
+		"example.com/a/a.go":        "package a\n\nimport (\n\t\"github.com/dep/dep\"\n\t\"gopkgs.com/x.v1\"\n\t\"gopkgs.com/y.v1\"\n)\n\nvar _, IsT = dep.Get().(x.T)\n\nvar Y y.T\n",
+	})
+	fset, pkg, files := parsePackage(t, "example.com/a")
+	rec := &record{}
+	disabled, err := keptImports(fset, pkg, files, isPinnedImport, false, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !disabled["gopkgs.com/x.v1"] {
+		t.Error("expecting gopkgs.com/x.v1 to be kept, since it's used in a type assertion on a value from a package which imports it")
+	}
+	if disabled["gopkgs.com/y.v1"] {
+		t.Error("expecting gopkgs.com/y.v1 to be upgradable")
+	}
+	if !disabled["github.com/dep/dep"] {
+		t.Error("expecting imports which are not pinned to be left untouched")
+	}
+	if len(rec.Skipped) != 1 || rec.Skipped[0].Import != "gopkgs.com/x.v1" {
+		t.Errorf("unexpected skipped imports %+v", rec.Skipped)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// vcs represents a version control system used by
+// a local checkout.
+type vcs struct {
+	// Name is the name of the VCS command
+	Name string
+	// Dir is the name of the metadata directory at the
+	// root of the checkout. For git worktrees and submodules,
+	// it's a file pointing to the actual metadata directory.
+	Dir string
+	// RevisionArgs are the arguments passed to the
+	// command to print the current revision.
+	RevisionArgs []string
+	// FetchArgs are the arguments passed to the command
+	// to fetch new revisions from the default remote. It's
+	// empty for VCSs which fetch them while checking out.
+	FetchArgs []string
+	// CheckoutArgs are the arguments passed to the command
+	// to check out a revision. {rev} is replaced by the
+	// revision.
+	CheckoutArgs []string
+	// CloneArgs are the arguments passed to the command to
+	// clone a repository. {remote} is replaced by the remote
+	// URL and {dir} by the destination directory. It's empty
+	// for VCSs which are only supported for existing checkouts.
+	CloneArgs []string
+	// UpdateArgs are the arguments passed to the command to
+	// fetch new revisions from the default remote and update
+	// the working copy to the latest one.
+	UpdateArgs []string
+	// StatusArgs are the arguments passed to the command to
+	// list the modified and untracked files in the working copy.
+	StatusArgs []string
+	// UnpushedChecks are the commands which look for changes
+	// not present in the default remote, like local commits,
+	// branches or stashes. They're empty for VCSs which
+	// commit directly to the remote.
+	UnpushedChecks []*vcsCheck
+}
+
+// vcsCheck is a VCS command which checks for some kind of changes.
+type vcsCheck struct {
+	// Args are the arguments passed to the command
+	Args []string
+	// NoneStatus is the exit status of the command when there
+	// are no changes. Unless IgnoreOutput is true, the command
+	// must also produce no output.
+	NoneStatus int
+	// ChangesStatus is the exit status of the command when there
+	// are changes. Any other exit status is considered an error.
+	ChangesStatus int
+	// IgnoreOutput is true for commands which print a message
+	// even when there are no changes.
+	IgnoreOutput bool
+}
+
+var (
+	vcsGit = &vcs{
+		Name:         "git",
+		Dir:          ".git",
+		RevisionArgs: []string{"rev-parse", "HEAD"},
+		FetchArgs:    []string{"fetch", "--tags", "origin"},
+		// -- makes git never interpret the revision as a path
+		CheckoutArgs: []string{"checkout", "-q", "{rev}", "--"},
+		CloneArgs:    []string{"clone", "-q", "{remote}", "{dir}"},
+		UpdateArgs:   []string{"pull", "-q", "--ff-only"},
+		StatusArgs:   []string{"status", "--porcelain"},
+		UnpushedChecks: []*vcsCheck{
+			// Commits in local branches or in a detached HEAD
+			{Args: []string{"log", "--oneline", "--branches", "HEAD", "--not", "--remotes"}},
+			{Args: []string{"stash", "list"}},
+		},
+	}
+	vcsHg = &vcs{
+		Name:         "hg",
+		Dir:          ".hg",
+		RevisionArgs: []string{"log", "-r", ".", "--template", "{node}"},
+		FetchArgs:    []string{"pull"},
+		CheckoutArgs: []string{"update", "-r", "{rev}"},
+		CloneArgs:    []string{"clone", "-q", "{remote}", "{dir}"},
+		UpdateArgs:   []string{"pull", "-q", "-u"},
+		StatusArgs:   []string{"status"},
+		UnpushedChecks: []*vcsCheck{
+			{Args: []string{"outgoing", "-q"}, NoneStatus: 1, ChangesStatus: 0},
+		},
+	}
+	vcsSvn = &vcs{
+		Name:         "svn",
+		Dir:          ".svn",
+		RevisionArgs: []string{"info", "--show-item", "revision"},
+		CheckoutArgs: []string{"update", "-q", "-r", "{rev}"},
+		UpdateArgs:   []string{"update", "-q"},
+		StatusArgs:   []string{"status"},
+	}
+	vcsBzr = &vcs{
+		Name:         "bzr",
+		Dir:          ".bzr",
+		RevisionArgs: []string{"version-info", "--custom", "--template={revno}"}, // ids are not hexadecimal
+		FetchArgs:    []string{"pull", "-q"},
+		CheckoutArgs: []string{"update", "-q", "-r", "{rev}"},
+		UpdateArgs:   []string{"pull", "-q"},
+		StatusArgs:   []string{"status"},
+		UnpushedChecks: []*vcsCheck{
+			{Args: []string{"missing", "-q", "--mine-only"}, NoneStatus: 0, ChangesStatus: 1, IgnoreOutput: true},
+		},
+	}
+	vcsList = []*vcs{vcsGit, vcsHg, vcsSvn, vcsBzr}
+)
+
+// run runs the VCS command with the given arguments at dir,
+// returning its output.
+func (v *vcs) run(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command(v.Name, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %s %s", v.Name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Revision returns the revision currently checked
+// out in the repository at dir.
+func (v *vcs) Revision(dir string) (string, error) {
+	out, err := v.run(dir, v.RevisionArgs...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Dirty returns true iff the working copy of the repository
+// at dir has modified or untracked files.
+func (v *vcs) Dirty(dir string) (bool, error) {
+	out, err := v.run(dir, v.StatusArgs...)
+	if err != nil {
+		return false, err
+	}
+	return len(bytes.TrimSpace(out)) > 0, nil
+}
+
+// Unpushed returns true iff the repository at dir has changes which
+// are not present in its default remote, as reported by the commands
+// in UnpushedChecks.
+func (v *vcs) Unpushed(dir string) (bool, error) {
+	for _, c := range v.UnpushedChecks {
+		cmd := exec.Command(v.Name, c.Args...)
+		cmd.Dir = dir
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		status := 0
+		if err != nil {
+			exitErr, ok := err.(*exec.ExitError)
+			if !ok {
+				return false, fmt.Errorf("%s %s: %s", v.Name, strings.Join(c.Args, " "), err)
+			}
+			status = exitErr.ExitCode()
+		}
+		switch {
+		case status == c.NoneStatus && (c.IgnoreOutput || len(bytes.TrimSpace(out)) == 0):
+			continue
+		case status == c.ChangesStatus:
+			return true, nil
+		}
+		return false, fmt.Errorf("%s %s: exit status %d %s", v.Name, strings.Join(c.Args, " "), status, strings.TrimSpace(stderr.String()))
+	}
+	return false, nil
+}
+
+// Clone clones the repository at remote into dir, which
+// must not exist.
+func (v *vcs) Clone(remote string, dir string) error {
+	args := make([]string, len(v.CloneArgs))
+	for ii, a := range v.CloneArgs {
+		a = strings.Replace(a, "{remote}", remote, -1)
+		args[ii] = strings.Replace(a, "{dir}", dir, -1)
+	}
+	_, err := v.run(filepath.Dir(dir), args...)
+	return err
+}
+
+// Update fetches new revisions from the default remote into the
+// repository at dir and updates its working copy to the latest one.
+func (v *vcs) Update(dir string) error {
+	_, err := v.run(dir, v.UpdateArgs...)
+	return err
+}
+
+// Checkout checks out the given revision in the repository at dir. If
+// the revision is not available locally, new revisions are fetched from
+// the default remote before trying again.
+func (v *vcs) Checkout(dir string, rev string) error {
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return fmt.Errorf("invalid revision %q", rev)
+	}
+	args := make([]string, len(v.CheckoutArgs))
+	for ii, a := range v.CheckoutArgs {
+		args[ii] = strings.Replace(a, "{rev}", rev, -1)
+	}
+	_, err := v.run(dir, args...)
+	if err == nil || len(v.FetchArgs) == 0 {
+		return err
+	}
+	if _, err := v.run(dir, v.FetchArgs...); err != nil {
+		return err
+	}
+	_, err = v.run(dir, args...)
+	return err
+}
+
+// vcsAt returns the VCS used by the checkout rooted at dir, or
+// nil if dir is not the root of a checkout.
+func vcsAt(dir string) *vcs {
+	for _, v := range vcsList {
+		if _, err := os.Stat(filepath.Join(dir, v.Dir)); err == nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// vcsRoot returns the root directory and the VCS of the checkout
+// containing dir, stopping the search at root.
+func vcsRoot(dir string, root string) (string, *vcs, error) {
+	dir = filepath.Clean(dir)
+	root = filepath.Clean(root)
+	for {
+		if v := vcsAt(dir); v != nil {
+			return dir, v, nil
+		}
+		parent := filepath.Dir(dir)
+		if dir == root || parent == dir || !strings.HasPrefix(parent, root) {
+			break
+		}
+		dir = parent
+	}
+	return "", nil, fmt.Errorf("directory %s is not inside a known VCS checkout", dir)
+}
+
+// packageVCSRoot returns the root directory and the VCS of the local
+// checkout of the package with the given import path.
+func packageVCSRoot(importPath string) (string, *vcs, error) {
+	pkg, err := build.Import(importPath, "", build.FindOnly)
+	if err != nil {
+		return "", nil, err
+	}
+	return vcsRoot(pkg.Dir, pkg.SrcRoot)
+}
+
+// localRevision returns the revision checked out in the
+// local copy of the package with the given import path.
+func localRevision(importPath string) (string, error) {
+	dir, v, err := packageVCSRoot(importPath)
+	if err != nil {
+		return "", err
+	}
+	return v.Revision(dir)
+}
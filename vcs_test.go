@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// requireGit skips the test if git is not installed.
+func requireGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+}
+
+// runGit runs git with the given arguments at dir, failing
+// the test if it doesn't succeed, and returns its output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=gopkgs", "GIT_AUTHOR_EMAIL=gopkgs@example.com",
+		"GIT_COMMITTER_NAME=gopkgs", "GIT_COMMITTER_EMAIL=gopkgs@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitFile writes the given file in the checkout at dir and
+// commits it, returning the new revision.
+func commitFile(t *testing.T, dir string, name string, data string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", name)
+	runGit(t, dir, "commit", "-q", "-m", "update "+name)
+	return runGit(t, dir, "rev-parse", "HEAD")
+}
+
+// newBareRepo creates a bare git repository named repo.git with a
+// master branch containing a single commit. It returns the path to
+// the bare repository and to a clone of it, which can be used to
+// push new commits.
+func newBareRepo(t *testing.T) (string, string) {
+	t.Helper()
+	requireGit(t)
+	tmp := t.TempDir()
+	bare := filepath.Join(tmp, "repo.git")
+	runGit(t, tmp, "init", "-q", "--bare", "-b", "master", bare)
+	work := filepath.Join(tmp, "work")
+	runGit(t, tmp, "clone", "-q", bare, work)
+	commitFile(t, work, "a.go", "package a\n")
+	runGit(t, work, "push", "-q", "origin", "HEAD:master")
+	return bare, work
+}
+
+func TestGitUnpushed(t *testing.T) {
+	bare, _ := newBareRepo(t)
+	dir := filepath.Join(t.TempDir(), "checkout")
+	runGit(t, filepath.Dir(dir), "clone", "-q", bare, dir)
+	check := func(name string, want bool) {
+		t.Helper()
+		got, err := vcsGit.Unpushed(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: Unpushed = %v, want %v", name, got, want)
+		}
+	}
+	check("fresh clone", false)
+	if dirty, err := vcsGit.Dirty(dir); err != nil || dirty {
+		t.Fatalf("fresh clone: Dirty = %v, %v, want false", dirty, err)
+	}
+	// Changes in the working copy are stashed, so they're
+	// not detected by Dirty anymore.
+	os.WriteFile(filepath.Join(dir, "a.go"), []byte("package b\n"), 0644)
+	runGit(t, dir, "stash", "-q")
+	check("stash", true)
+	runGit(t, dir, "stash", "drop", "-q")
+	check("dropped stash", false)
+	// Commit on another branch, then go back to master
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	commitFile(t, dir, "b.go", "package a\n")
+	runGit(t, dir, "checkout", "-q", "master")
+	check("local branch", true)
+	runGit(t, dir, "branch", "-q", "-D", "feature")
+	check("deleted branch", false)
+	// Commit on a detached HEAD
+	runGit(t, dir, "checkout", "-q", "--detach")
+	commitFile(t, dir, "c.go", "package a\n")
+	check("detached HEAD", true)
+}
+
+func TestGitUnpushedError(t *testing.T) {
+	requireGit(t)
+	if _, err := vcsGit.Unpushed(t.TempDir()); err == nil {
+		t.Error("expecting an error outside of a repository")
+	}
+}
+
+func TestGitCheckout(t *testing.T) {
+	bare, work := newBareRepo(t)
+	first := runGit(t, work, "rev-parse", "HEAD")
+	// A file named like the revision must not be taken as a path
+	commitFile(t, work, first[:12], "package a\n")
+	runGit(t, work, "push", "-q", "origin", "HEAD:master")
+	dir := filepath.Join(t.TempDir(), "repo")
+	runGit(t, filepath.Dir(dir), "clone", "-q", bare, dir)
+	if err := vcsGit.Checkout(dir, first[:12]); err != nil {
+		t.Fatal(err)
+	}
+	if h := runGit(t, dir, "rev-parse", "HEAD"); h != first {
+		t.Errorf("expecting %s after checking it out, got %s", first, h)
+	}
+	for _, v := range []string{"", "-b", "--orphan=x"} {
+		if err := vcsGit.Checkout(dir, v); err == nil {
+			t.Errorf("expecting an error when checking out revision %q", v)
+		}
+	}
+	if b := runGit(t, dir, "branch", "--list", "x"); b != "" {
+		t.Errorf("checkout created branch %q", b)
+	}
+}
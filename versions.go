@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"gopkgs.com/cmd/gopkgs/lib"
+)
+
+type versionsOptions struct {
+	Revisions int `name:"n" help:"Number of latest revisions to list"`
+}
+
+const (
+	dateFormat = "2006-01-02 15:04"
+)
+
+func versionsCommand(args []string, opts *versionsOptions) error {
+	if len(args) == 0 {
+		return errors.New("missing package import path")
+	}
+	req := &lib.VersionsRequest{
+		Path:      args[0],
+		Revisions: opts.Revisions,
+	}
+	resp, err := Versions([]*lib.VersionsRequest{req})
+	if err != nil {
+		return err
+	}
+	rv := resp[0]
+	if jsonOutput() {
+		emit(&record{Command: "versions", ImportPath: req.Path, Versions: rv, Error: rv.Error})
+	}
+	if rv.Error != "" {
+		return errors.New(rv.Error)
+	}
+	if jsonOutput() {
+		return nil
+	}
+	fmt.Fprintf(stdout, "%s (%s)\n\n", rv.GoPkgsPath, rv.Path)
+	w := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+	if len(rv.Versions) == 0 {
+		fmt.Fprintln(w, "no versions available")
+	} else {
+		fmt.Fprintln(w, "VERSION\tIMPORT PATH\tTAG\tCOMMIT\tDATE")
+		// Most recent first, like revisions
+		for ii := len(rv.Versions) - 1; ii >= 0; ii-- {
+			v := rv.Versions[ii]
+			fmt.Fprintf(w, "v%d\t%s\t%s\t%s\t%s\n", v.Number, rv.VersionImportPath(v), v.Tag, v.Commit, v.Date.Local().Format(dateFormat))
+		}
+	}
+	w.Flush()
+	if len(rv.Revisions) > 0 {
+		fmt.Fprintln(stdout)
+		fmt.Fprintln(w, "REVISION\tIMPORT PATH\tDATE\tSUBJECT")
+		for _, v := range rv.Revisions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Commit, rv.RevisionImportPath(v), v.Date.Local().Format(dateFormat), v.Subject)
+		}
+		w.Flush()
+	}
+	return nil
+}
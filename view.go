@@ -16,7 +16,13 @@ func viewCommand(args []string) error {
 	}
 	repo, err := Repo(req)
 	if err != nil {
+		emit(&record{Command: "view", ImportPath: req.Path, Error: err.Error()})
 		return err
 	}
-	return browser.Open(repo.GoPkgsPath)
+	url := "https://" + repo.GoPkgsPath
+	if jsonOutput() {
+		emit(&record{Command: "view", Repo: repo, URL: url})
+		return nil
+	}
+	return browser.Open(url)
 }